@@ -29,14 +29,23 @@ package backoff
 
 import (
 	"context"
-	"math"
-	"math/rand"
 	"sync/atomic"
 	"time"
 
 	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/metrics"
+	"github.com/wtsi-ssg/wr/tracing"
 )
 
+// logger is used for all our logging, as the "backoff" subsystem.
+const logger clog.Logger = "backoff"
+
+// sleepHistogram returns the Histogram in metrics.Default of the durations of
+// all Backoff sleeps.
+func sleepHistogram() *metrics.Histogram {
+	return metrics.Default.Histogram("wr_backoff_sleep_seconds", "Durations of Backoff sleeps.", nil)
+}
+
 // Sleeper defines the Sleep method used by a Backoff.
 type Sleeper interface {
 	// Sleep sleeps for the given duration, stopping early if context is
@@ -44,6 +53,26 @@ type Sleeper interface {
 	Sleep(context.Context, time.Duration)
 }
 
+// Clock is a Sleeper that also knows the current time, letting code that needs
+// to both sleep and tell the time be tested with a fake clock.
+type Clock interface {
+	Sleeper
+
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// Counter stores the number of Sleep() calls a Backoff has made in a row.
+// Implementations must be concurrent safe.
+type Counter interface {
+	// Increment adds 1 to the count, returning the count prior to the
+	// increment.
+	Increment() uint64
+
+	// Reset sets the count back to 0.
+	Reset()
+}
+
 // Backoff is used to sleep for increasing periods of time.
 type Backoff struct {
 	// Min is the minimum amount of time to sleep for.
@@ -53,58 +82,169 @@ type Backoff struct {
 	Max time.Duration
 
 	// Factor is the multiplying factor to apply to the time to sleep for on
-	// each Sleep() call.
+	// each Sleep() call. It is only used if Strategy is nil.
 	Factor float64
 
+	// Strategy is an implementation of Strategy, to determine how the sleep
+	// duration changes with each Sleep() call. If nil, the sleep duration
+	// grows exponentially by Factor.
+	Strategy Strategy
+
+	// Jitter determines how sleep durations are randomised. The default is
+	// JitterDefault.
+	Jitter JitterMode
+
+	// Rand is the source of randomness used for jitter. If nil, the global
+	// math/rand functions are used. Supply NewSeededRandom() to be able to
+	// reproduce jittered sleep durations.
+	Rand Random
+
 	// Sleeper is an implementation of Sleeper, to determine how the sleep
 	// actually happens.
 	Sleeper Sleeper
 
-	sleeps uint64 // number of Sleep() calls in a row.
+	// Counter is an implementation of Counter, to store the number of Sleep()
+	// calls in a row. If nil, a count private to this Backoff is kept in
+	// memory. Supply a shared Counter (like the one in the backoff/file
+	// package) to have multiple Backoffs, even in different processes, back off
+	// together and Reset() together.
+	Counter Counter
+
+	sleeps uint64 // number of Sleep() calls in a row, if Counter is nil.
+	prev   int64  // duration of the previous Sleep() call.
 }
 
 // Sleep will sleep (using Sleeper.Sleep()) for Min on the first call,
-// increasing the sleep duration by Factor up to Max on each subsequent call.
+// increasing the sleep duration according to Strategy (by default, by Factor)
+// up to Max on each subsequent call.
 //
-// Sleep times in between Min and Max are jittered so multiple Backoffs working
-// at the same time don't all sleep for the same time periods.
+// Sleep times in between Min and Max are jittered according to Jitter so
+// multiple Backoffs working at the same time don't all sleep for the same time
+// periods, unless Strategy is a self-jittering one like DecorrelatedJitter.
 //
-// If the supplied context is cancelled, we stop sleeping early.
+// If the supplied context is cancelled, we stop sleeping early. If it has a
+// deadline, the sleep is shortened so as not to go past it.
 //
-// Sleep durations are logged using the global context logger at debug level.
+// Sleep durations are logged using the "backoff" clog subsystem at debug level.
 func (b *Backoff) Sleep(ctx context.Context) {
-	d := b.duration()
-	clog.Debug(ctx, "backoff", "sleep", d)
+	b.SleepBefore(ctx, time.Time{})
+}
+
+// SleepBefore is like Sleep(), but also shortens the sleep so as not to go past
+// the given deadline, as measured by our Sleeper if it is a Clock, or else real
+// time. A zero deadline means no deadline.
+func (b *Backoff) SleepBefore(ctx context.Context, deadline time.Time) {
+	b.SleepFor(ctx, b.Next(ctx, deadline))
+}
+
+// Next returns the duration that SleepBefore() would sleep for given the same
+// arguments, and advances our state as if that sleep had happened. Use it with
+// SleepFor() when you need to know how long you will sleep for in advance.
+func (b *Backoff) Next(ctx context.Context, deadline time.Time) time.Duration {
+	return b.durationBeforeDeadlines(ctx, b.duration(), deadline)
+}
+
+// SleepFor sleeps (using Sleeper.Sleep()) for the given duration, which should
+// have come from Next(). The duration is logged the same way as in Sleep(),
+// recorded as an event on the context's tracing.Span, if any, and observed in
+// a histogram in metrics.Default.
+func (b *Backoff) SleepFor(ctx context.Context, d time.Duration) {
+	logger.Debug(ctx, "backoff", "sleep", d)
+	sleepHistogram().Observe(d.Seconds())
+	tracing.SpanFromContext(ctx).AddEvent("backoff sleep", "duration", d)
 	b.Sleeper.Sleep(ctx, d)
 }
 
+// durationBeforeDeadlines returns d, shortened if necessary so that a sleep of
+// that duration would not go past the context's deadline or the given
+// deadline.
+func (b *Backoff) durationBeforeDeadlines(ctx context.Context, d time.Duration, deadline time.Time) time.Duration {
+	if ctxDeadline, ok := ctx.Deadline(); ok {
+		d = shortestDuration(d, time.Until(ctxDeadline))
+	}
+
+	if !deadline.IsZero() {
+		d = shortestDuration(d, deadline.Sub(b.now()))
+	}
+
+	return d
+}
+
+// now returns the current time according to our Sleeper if it is a Clock, or
+// else real time.
+func (b *Backoff) now() time.Time {
+	if clock, ok := b.Sleeper.(Clock); ok {
+		return clock.Now()
+	}
+
+	return time.Now()
+}
+
+// shortestDuration returns the shorter of d and limit, but not less than 0.
+func shortestDuration(d, limit time.Duration) time.Duration {
+	if limit < d {
+		d = limit
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
 // duration calculates the next amount of time we should Sleep() for.
 func (b *Backoff) duration() time.Duration {
-	sleeps := atomic.AddUint64(&b.sleeps, 1) - 1
+	sleeps := b.incrementSleeps()
 	d := b.durationAfterSleeps(sleeps)
-	d = b.jitter(d, sleeps)
+	d = b.durationWithinBounds(b.jitter(d, sleeps))
+	atomic.StoreInt64(&b.prev, int64(d))
 
-	return b.durationWithinBounds(d)
+	return d
+}
+
+// incrementSleeps increments our count of Sleep() calls, returning the count
+// prior to the increment.
+func (b *Backoff) incrementSleeps() uint64 {
+	if b.Counter != nil {
+		return b.Counter.Increment()
+	}
+
+	return atomic.AddUint64(&b.sleeps, 1) - 1
+}
+
+// previous returns the duration of the previous Sleep() call.
+func (b *Backoff) previous() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.prev))
 }
 
 // durationAfterSleeps calculates the duration we should sleep for after the
-// given number of Sleep() calls.
+// given number of Sleep() calls, using our Strategy.
 func (b *Backoff) durationAfterSleeps(sleeps uint64) time.Duration {
-	return time.Duration(float64(b.Min) * math.Pow(b.Factor, float64(sleeps)))
+	return b.strategy().Duration(b.Min, b.Max, sleeps)
 }
 
-// jitter alters the given duration by subtracting a random amount of time from
-// it (but not so it is less than the previous unjittered sleep time). If
-// sleeps is 0 (there is no previous sleep time), applies no jitter, since
-// that would violate Min.
+// strategy returns our Strategy, defaulting to Exponential by Factor.
+func (b *Backoff) strategy() Strategy {
+	if b.Strategy == nil {
+		return &Exponential{Factor: b.Factor}
+	}
+
+	return b.Strategy
+}
+
+// jitter alters the given duration by picking a random duration within the
+// range determined by our Jitter mode. If sleeps is 0 (there is no previous
+// sleep time), applies no jitter, since that would violate Min. Self-jittering
+// Strategies are also not jittered.
 func (b *Backoff) jitter(d time.Duration, sleeps uint64) time.Duration {
-	if sleeps == 0 {
+	if _, selfJittering := b.Strategy.(selfJitteringStrategy); sleeps == 0 || selfJittering {
 		return d
 	}
 
-	prev := b.durationAfterSleeps(sleeps - 1)
+	lower, upper := b.jitterRange(d, sleeps)
 
-	return time.Duration((rand.Float64() * float64(d-prev)) + float64(prev)) // #nosec
+	return randomBetween(randomOrGlobal(b.Rand), lower, upper)
 }
 
 // durationWithinBounds returns d but not less than Min and not more than Max.
@@ -120,7 +260,13 @@ func (b *Backoff) durationWithinBounds(d time.Duration) time.Duration {
 	return d
 }
 
-// Reset will cause the next Sleep() call to sleep for Min again.
+// Reset will cause the next Sleep() call to sleep for Min again. If Counter is
+// shared, this affects all Backoffs sharing it.
 func (b *Backoff) Reset() {
+	if b.Counter != nil {
+		b.Counter.Reset()
+	}
+
 	atomic.StoreUint64(&b.sleeps, 0)
+	atomic.StoreInt64(&b.prev, 0)
 }
@@ -50,9 +50,12 @@ func TestBackoff(t *testing.T) {
 		}
 
 		Convey("It Sleep()s for Min", func() {
+			count, sum := sleepHistogram().Count(), sleepHistogram().Sum()
 			b.Sleep(ctx)
 			So(sleeper.Invoked(), ShouldEqual, 1)
 			So(sleeper.Elapsed(), ShouldEqual, 1*time.Millisecond)
+			So(sleepHistogram().Count(), ShouldEqual, count+1)
+			So(sleepHistogram().Sum(), ShouldAlmostEqual, sum+0.001)
 
 			Convey("The next call Sleep()s for Min*Factor, with jitter", func() {
 				b.Sleep(ctx)
@@ -186,4 +189,51 @@ func TestBackoff(t *testing.T) {
 		So(sleeper.Invoked(), ShouldEqual, 5)
 		So(base.Add(sleeper.Elapsed()), ShouldHappenOnOrBetween, base.Add(4*time.Millisecond), base.Add(5*time.Millisecond))
 	})
+
+	Convey("A Backoff does not sleep past deadlines", t, func() {
+		clock := mock.NewClock(base)
+		b := &Backoff{
+			Min:     10 * time.Millisecond,
+			Max:     10 * time.Millisecond,
+			Factor:  1,
+			Sleeper: clock,
+		}
+
+		So(b.durationBeforeDeadlines(ctx, b.Min, time.Time{}), ShouldEqual, b.Min)
+		So(b.durationBeforeDeadlines(ctx, b.Min, base.Add(3*time.Millisecond)), ShouldEqual, 3*time.Millisecond)
+		So(b.durationBeforeDeadlines(ctx, b.Min, base.Add(-3*time.Millisecond)), ShouldEqual, 0)
+
+		dctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+		defer cancel()
+
+		So(b.durationBeforeDeadlines(dctx, b.Min, time.Time{}), ShouldBeBetweenOrEqual, 0, 5*time.Millisecond)
+
+		Convey("Which is logged", func() {
+			buff := clog.ToBufferAtLevel("debug")
+			defer clog.ToDefault()
+
+			b.SleepBefore(ctx, base)
+			So(buff.String(), ShouldContainSubstring, "sleep=0s")
+		})
+	})
+
+	Convey("You can find out the next sleep duration before sleeping for it", t, func() {
+		sleeper := &mock.Sleeper{}
+		b := &Backoff{
+			Min:     1 * time.Millisecond,
+			Max:     10 * time.Millisecond,
+			Factor:  2,
+			Jitter:  JitterNone,
+			Sleeper: sleeper,
+		}
+
+		So(b.Next(ctx, time.Time{}), ShouldEqual, 1*time.Millisecond)
+		d := b.Next(ctx, time.Time{})
+		So(d, ShouldEqual, 2*time.Millisecond)
+		So(sleeper.Invoked(), ShouldEqual, 0)
+
+		b.SleepFor(ctx, d)
+		So(sleeper.Invoked(), ShouldEqual, 1)
+		So(sleeper.Elapsed(), ShouldEqual, d)
+	})
 }
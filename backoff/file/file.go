@@ -0,0 +1,155 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package file contains a file-based implementation of backoff.Counter, for
+// sharing Backoff state between processes on the same host.
+package file
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/wtsi-ssg/wr/clog"
+)
+
+// logger is used for all our logging, as the "backoff" subsystem.
+const logger clog.Logger = "backoff"
+
+const counterFilePerms = 0600
+
+// Counter represents an implementation of backoff.Counter that stores the count
+// in a file, locking the file while reading and writing it. All Backoffs using
+// a Counter with the same path, in any process, share the same count, so back
+// off together and are Reset() together.
+//
+// Locking is only supported on Unix-like systems; elsewhere the file can't be
+// used.
+//
+// If the file can't be used, errors are logged using the "backoff" clog
+// subsystem at warn level, and a count private to this Counter is used instead.
+type Counter struct {
+	path     string
+	fallback uint64
+}
+
+// NewCounter returns a Counter that stores its count in a file at the given
+// path, creating it if necessary. Returns an error if the file can't be
+// created or opened.
+func NewCounter(path string) (*Counter, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Counter{path: path}, f.Close()
+}
+
+// openFile opens or creates the file at the given path for reading and
+// writing.
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, counterFilePerms)
+}
+
+// Increment adds 1 to the count stored in our file, returning the count prior
+// to the increment.
+func (c *Counter) Increment() uint64 {
+	var count uint64
+
+	err := c.withLockedFile(func(f *os.File) error {
+		current, err := readCount(f)
+		if err != nil {
+			return err
+		}
+
+		count = current
+
+		return writeCount(f, current+1)
+	})
+	if err != nil {
+		c.warn("increment", err)
+
+		return atomic.AddUint64(&c.fallback, 1) - 1
+	}
+
+	return count
+}
+
+// Reset sets the count stored in our file back to 0.
+func (c *Counter) Reset() {
+	atomic.StoreUint64(&c.fallback, 0)
+
+	err := c.withLockedFile(func(f *os.File) error {
+		return writeCount(f, 0)
+	})
+	if err != nil {
+		c.warn("reset", err)
+	}
+}
+
+// withLockedFile opens our file, takes an exclusive lock on it and calls the
+// given function with it, before closing the file (which releases the lock).
+func (c *Counter) withLockedFile(fn func(*os.File) error) error {
+	f, err := openFile(c.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = lockFile(f); err != nil {
+		return err
+	}
+
+	return fn(f)
+}
+
+// readCount reads the count from the start of the given file, treating an
+// empty file as a count of 0.
+func readCount(f *os.File) (uint64, error) {
+	var count uint64
+
+	err := binary.Read(f, binary.BigEndian, &count)
+	if err == io.EOF {
+		return 0, nil
+	}
+
+	return count, err
+}
+
+// writeCount writes the given count to the start of the given file.
+func writeCount(f *os.File, count uint64) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return binary.Write(f, binary.BigEndian, count)
+}
+
+// warn logs that the given action failed with the given error.
+func (c *Counter) warn(action string, err error) {
+	logger.Warn(context.Background(), "shared backoff counter failed", "action", action, "path", c.path, "err", err)
+}
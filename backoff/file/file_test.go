@@ -0,0 +1,138 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	"github.com/wtsi-ssg/wr/backoff/mock"
+	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/internal"
+)
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Counter implements backoff.Counter", t, func() {
+		var _ backoff.Counter = (*Counter)(nil)
+	})
+
+	Convey("You can't make a Counter with a bad path", t, func() {
+		_, err := NewCounter("/!/*&^%$/counter")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given Counters sharing a file", t, func() {
+		path := internal.FilePathInTempDir(t, "backoff.counter")
+		c1, err := NewCounter(path)
+		So(err, ShouldBeNil)
+		c2, err := NewCounter(path)
+		So(err, ShouldBeNil)
+
+		Convey("Increments are shared", func() {
+			So(c1.Increment(), ShouldEqual, 0)
+			So(c2.Increment(), ShouldEqual, 1)
+			So(c1.Increment(), ShouldEqual, 2)
+
+			Convey("And so are Resets", func() {
+				c2.Reset()
+				So(c1.Increment(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("Increments are concurrent safe", func() {
+			wg := &sync.WaitGroup{}
+			n := 20
+			wg.Add(n)
+
+			for i := 0; i < n; i++ {
+				c := c1
+				if i%2 == 0 {
+					c = c2
+				}
+
+				go func() {
+					defer wg.Done()
+					c.Increment()
+				}()
+			}
+
+			wg.Wait()
+			So(c1.Increment(), ShouldEqual, n)
+		})
+
+		Convey("Backoffs using them back off and Reset() together", func() {
+			sleeper := &mock.Sleeper{}
+			newBackoff := func(c *Counter) *backoff.Backoff {
+				return &backoff.Backoff{
+					Min:     1 * time.Millisecond,
+					Max:     100 * time.Millisecond,
+					Factor:  2,
+					Jitter:  backoff.JitterNone,
+					Sleeper: sleeper,
+					Counter: c,
+				}
+			}
+
+			b1, b2 := newBackoff(c1), newBackoff(c2)
+			b1.Sleep(ctx)
+			b1.Sleep(ctx)
+			So(sleeper.Elapsed(), ShouldEqual, 3*time.Millisecond)
+			b2.Sleep(ctx)
+			So(sleeper.Elapsed(), ShouldEqual, 7*time.Millisecond)
+
+			b2.Reset()
+			b1.Sleep(ctx)
+			So(sleeper.Elapsed(), ShouldEqual, 8*time.Millisecond)
+		})
+
+		Convey("If the file becomes unusable, a private count is used and warnings are logged", func() {
+			buff := clog.ToBufferAtLevel("warn")
+			defer clog.ToDefault()
+
+			err = ioutil.WriteFile(path, []byte("abc"), counterFilePerms)
+			So(err, ShouldBeNil)
+
+			So(c1.Increment(), ShouldEqual, 0)
+			So(c1.Increment(), ShouldEqual, 1)
+			So(buff.String(), ShouldContainSubstring, "msg=\"shared backoff counter failed\"")
+			So(buff.String(), ShouldContainSubstring, "action=increment")
+
+			c1.Reset()
+			So(c1.Increment(), ShouldEqual, 0)
+			So(c2.Increment(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,138 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package backoff
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// JitterMode determines how a Backoff randomises its sleep durations.
+type JitterMode string
+
+// Jitter* constants are the valid values of Backoff.Jitter.
+const (
+	// JitterDefault picks a random duration between the previous unjittered
+	// duration and the current one.
+	JitterDefault JitterMode = ""
+
+	// JitterNone does no jittering, so that sleeps are deterministic.
+	JitterNone JitterMode = "none"
+
+	// JitterFull picks a random duration between Min and the current
+	// unjittered duration.
+	JitterFull JitterMode = "full"
+
+	// JitterEqual picks a random duration between half the current unjittered
+	// duration and the whole of it.
+	JitterEqual JitterMode = "equal"
+
+	// JitterDecorrelated picks a random duration between Min and 3 times the
+	// previous actual sleep, but not more than the current unjittered
+	// duration.
+	JitterDecorrelated JitterMode = "decorrelated"
+)
+
+// Random is a source of random numbers used for jitter. *rand.Rand satisfies
+// this interface, but is not concurrent safe; see NewSeededRandom().
+type Random interface {
+	// Float64 returns a pseudo-random number in [0.0,1.0).
+	Float64() float64
+}
+
+// globalRandom implements Random using the global math/rand functions.
+type globalRandom struct{}
+
+// Float64 returns rand.Float64().
+func (r globalRandom) Float64() float64 {
+	return rand.Float64() // #nosec
+}
+
+// seededRandom implements Random in a concurrent safe way using a rand.Rand.
+type seededRandom struct {
+	rand *rand.Rand
+	mu   sync.Mutex
+}
+
+// NewSeededRandom returns a concurrent safe Random that generates a
+// reproducible sequence of numbers from the given seed.
+func NewSeededRandom(seed int64) Random {
+	return &seededRandom{rand: rand.New(rand.NewSource(seed))} // #nosec
+}
+
+// Float64 returns the next number in our seeded sequence.
+func (r *seededRandom) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rand.Float64()
+}
+
+// randomOrGlobal returns r, or a Random using the global math/rand functions
+// if r is nil.
+func randomOrGlobal(r Random) Random {
+	if r == nil {
+		return globalRandom{}
+	}
+
+	return r
+}
+
+// randomBetween returns a random duration between lower and upper using the
+// given Random.
+func randomBetween(r Random, lower, upper time.Duration) time.Duration {
+	return time.Duration((r.Float64() * float64(upper-lower)) + float64(lower))
+}
+
+// jitterRange returns the range of durations our Jitter mode wants to pick a
+// random duration from, given the current unjittered duration d after the
+// given number of sleeps.
+func (b *Backoff) jitterRange(d time.Duration, sleeps uint64) (time.Duration, time.Duration) {
+	switch b.Jitter {
+	case JitterNone:
+		return d, d
+	case JitterFull:
+		return b.Min, d
+	case JitterEqual:
+		return d / 2, d
+	case JitterDecorrelated:
+		return b.Min, b.decorrelatedUpper(d)
+	default:
+		return b.durationAfterSleeps(sleeps - 1), d
+	}
+}
+
+// decorrelatedUpper returns 3 times our previous actual sleep duration, but
+// not more than d.
+func (b *Backoff) decorrelatedUpper(d time.Duration) time.Duration {
+	upper := b.previous() * decorrelatedFactor
+	if upper > d || upper < 0 {
+		return d
+	}
+
+	return upper
+}
@@ -0,0 +1,137 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+func TestJitter(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now()
+
+	newBackoff := func(jitter JitterMode, sleeper *mock.Sleeper) *Backoff {
+		return &Backoff{
+			Min:     1 * time.Millisecond,
+			Max:     100 * time.Millisecond,
+			Factor:  4,
+			Jitter:  jitter,
+			Sleeper: sleeper,
+		}
+	}
+
+	sleepAndGetDuration := func(b *Backoff, sleeper *mock.Sleeper) time.Duration {
+		before := sleeper.Elapsed()
+		b.Sleep(ctx)
+
+		return sleeper.Elapsed() - before
+	}
+
+	Convey("JitterNone gives deterministic sleeps", t, func() {
+		sleeper := &mock.Sleeper{}
+		b := newBackoff(JitterNone, sleeper)
+		So(sleepAndGetDuration(b, sleeper), ShouldEqual, 1*time.Millisecond)
+		So(sleepAndGetDuration(b, sleeper), ShouldEqual, 4*time.Millisecond)
+		So(sleepAndGetDuration(b, sleeper), ShouldEqual, 16*time.Millisecond)
+	})
+
+	testRange := func(jitter JitterMode, lower, upper time.Duration) {
+		sleeper := &mock.Sleeper{}
+		b := newBackoff(jitter, sleeper)
+		So(sleepAndGetDuration(b, sleeper), ShouldEqual, 1*time.Millisecond)
+		sleepAndGetDuration(b, sleeper)
+		d := sleepAndGetDuration(b, sleeper)
+		So(base.Add(d), ShouldHappenOnOrBetween, base.Add(lower), base.Add(upper))
+	}
+
+	Convey("JitterDefault sleeps between the previous and current durations", t, func() {
+		testRange(JitterDefault, 4*time.Millisecond, 16*time.Millisecond)
+	})
+
+	Convey("JitterFull sleeps between Min and the current duration", t, func() {
+		testRange(JitterFull, 1*time.Millisecond, 16*time.Millisecond)
+	})
+
+	Convey("JitterEqual sleeps between half the current duration and all of it", t, func() {
+		testRange(JitterEqual, 8*time.Millisecond, 16*time.Millisecond)
+	})
+
+	Convey("JitterDecorrelated sleeps between Min and 3 times the previous sleep", t, func() {
+		sleeper := &mock.Sleeper{}
+		b := newBackoff(JitterDecorrelated, sleeper)
+		prev := sleepAndGetDuration(b, sleeper)
+
+		for i := 0; i < 5; i++ {
+			d := sleepAndGetDuration(b, sleeper)
+			So(base.Add(d), ShouldHappenOnOrBetween, base.Add(1*time.Millisecond), base.Add(3*prev))
+			prev = d
+		}
+
+		Convey("And Reset() forgets the previous sleep", func() {
+			b.Reset()
+			So(b.previous(), ShouldEqual, 0)
+			So(sleepAndGetDuration(b, sleeper), ShouldEqual, 1*time.Millisecond)
+		})
+	})
+
+	Convey("A seeded Random makes jittered sleeps reproducible", t, func() {
+		sleeps := func() []time.Duration {
+			sleeper := &mock.Sleeper{}
+			b := newBackoff(JitterFull, sleeper)
+			b.Rand = NewSeededRandom(42)
+			ds := make([]time.Duration, 4)
+
+			for i := range ds {
+				ds[i] = sleepAndGetDuration(b, sleeper)
+			}
+
+			return ds
+		}
+
+		first := sleeps()
+		So(sleeps(), ShouldResemble, first)
+
+		Convey("Including for DecorrelatedJitter", func() {
+			durations := func() []time.Duration {
+				s := &DecorrelatedJitter{Rand: NewSeededRandom(42)}
+				ds := make([]time.Duration, 4)
+
+				for i := range ds {
+					ds[i] = s.Duration(1*time.Millisecond, 100*time.Millisecond, uint64(i))
+				}
+
+				return ds
+			}
+
+			So(durations(), ShouldResemble, durations())
+		})
+	})
+}
@@ -0,0 +1,165 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package mock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sleeper is a Sleep() call that is blocked waiting for a Clock to be advanced
+// to its wakeAt time.
+type sleeper struct {
+	wakeAt time.Time
+	woken  chan struct{}
+}
+
+// Clock represents a virtual clock implementation of backoff.Clock. Time only
+// passes when you call Advance(), and Sleep() blocks until the clock has been
+// advanced past the end of the sleep, or the context is cancelled. It is
+// concurrent safe.
+type Clock struct {
+	now      time.Time
+	sleepers []*sleeper
+	changed  chan struct{}
+	mu       sync.Mutex
+}
+
+// NewClock returns a Clock with its current time set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the virtual current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep blocks until Advance() has moved the virtual time on by at least d, or
+// the context is cancelled. A d of 0 or less returns immediately.
+func (c *Clock) Sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	s := c.addSleeper(d)
+
+	select {
+	case <-s.woken:
+	case <-ctx.Done():
+		c.removeSleeper(s)
+	}
+}
+
+// addSleeper registers a new sleeper that should wake after d.
+func (c *Clock) addSleeper(d time.Duration) *sleeper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &sleeper{wakeAt: c.now.Add(d), woken: make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.notifyChanged()
+
+	return s
+}
+
+// removeSleeper unregisters the given sleeper, if it is still registered.
+func (c *Clock) removeSleeper(s *sleeper) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, other := range c.sleepers {
+		if other == s {
+			c.sleepers = append(c.sleepers[:i], c.sleepers[i+1:]...)
+			c.notifyChanged()
+
+			return
+		}
+	}
+}
+
+// notifyChanged wakes up anything waiting in WaitForSleepers(). You must hold
+// the lock before calling this.
+func (c *Clock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Advance moves the virtual time on by d, waking up any Sleep() calls that
+// should now have finished.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	remaining := c.sleepers[:0]
+
+	for _, s := range c.sleepers {
+		if c.now.Before(s.wakeAt) {
+			remaining = append(remaining, s)
+
+			continue
+		}
+
+		close(s.woken)
+	}
+
+	c.sleepers = remaining
+	c.notifyChanged()
+}
+
+// Sleepers returns the number of Sleep() calls currently blocked.
+func (c *Clock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.sleepers)
+}
+
+// WaitForSleepers blocks until at least n Sleep() calls are blocked, returning
+// true. If the context is cancelled first, returns false.
+func (c *Clock) WaitForSleepers(ctx context.Context, n int) bool {
+	for {
+		c.mu.Lock()
+		blocked, changed := len(c.sleepers), c.changed
+		c.mu.Unlock()
+
+		if blocked >= n {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
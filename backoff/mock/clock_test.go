@@ -0,0 +1,116 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package mock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+)
+
+func TestClock(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	Convey("Clock implements backoff.Clock", t, func() {
+		var _ backoff.Clock = (*Clock)(nil)
+	})
+
+	Convey("Given a Clock", t, func() {
+		clock := NewClock(start)
+		So(clock.Now(), ShouldEqual, start)
+
+		sleep := func(ctx context.Context, d time.Duration) chan bool {
+			done := make(chan bool)
+
+			go func() {
+				clock.Sleep(ctx, d)
+				close(done)
+			}()
+
+			return done
+		}
+
+		isDone := func(done chan bool) bool {
+			select {
+			case <-done:
+				return true
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}
+
+		Convey("Advance() moves Now() on", func() {
+			clock.Advance(1 * time.Second)
+			So(clock.Now(), ShouldEqual, start.Add(1*time.Second))
+		})
+
+		Convey("Sleep() with no duration returns immediately", func() {
+			clock.Sleep(ctx, 0)
+			So(clock.Sleepers(), ShouldEqual, 0)
+		})
+
+		Convey("Sleep() blocks until the clock is advanced enough", func() {
+			done1 := sleep(ctx, 1*time.Second)
+			done2 := sleep(ctx, 2*time.Second)
+			So(clock.WaitForSleepers(ctx, 2), ShouldBeTrue)
+			So(clock.Sleepers(), ShouldEqual, 2)
+
+			clock.Advance(500 * time.Millisecond)
+			So(isDone(done1), ShouldBeFalse)
+
+			clock.Advance(500 * time.Millisecond)
+			So(isDone(done1), ShouldBeTrue)
+			So(isDone(done2), ShouldBeFalse)
+			So(clock.Sleepers(), ShouldEqual, 1)
+
+			clock.Advance(1 * time.Second)
+			So(isDone(done2), ShouldBeTrue)
+			So(clock.Sleepers(), ShouldEqual, 0)
+		})
+
+		Convey("Sleep() stops blocking if the context is cancelled", func() {
+			cctx, cancel := context.WithCancel(ctx)
+			done := sleep(cctx, 1*time.Second)
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+
+			cancel()
+			So(isDone(done), ShouldBeTrue)
+			So(clock.Sleepers(), ShouldEqual, 0)
+			So(clock.Now(), ShouldEqual, start)
+		})
+
+		Convey("WaitForSleepers() can be cancelled", func() {
+			cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			So(clock.WaitForSleepers(cctx, 1), ShouldBeFalse)
+		})
+	})
+}
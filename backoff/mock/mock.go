@@ -23,7 +23,8 @@
  * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
  ******************************************************************************/
 
-// package mock contains a mock implementation of backoff.Sleeper
+// package mock contains mock implementations of backoff.Sleeper and
+// backoff.Clock.
 package mock
 
 import (
@@ -0,0 +1,159 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package backoff
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const decorrelatedFactor = 3
+
+// Strategy defines the curve of durations a Backoff sleeps for.
+type Strategy interface {
+	// Duration returns the duration to sleep for given the Backoff's Min and
+	// Max and the number of Sleep() calls in a row that were made before this
+	// one. Backoff will apply jitter to the result and bound it by Min and Max.
+	Duration(min, max time.Duration, sleeps uint64) time.Duration
+}
+
+// selfJitteringStrategy is implemented by Strategies that already randomise
+// their durations, which Backoff should then not jitter any further.
+type selfJitteringStrategy interface {
+	selfJittering()
+}
+
+// Constant implements Strategy, always sleeping for Min.
+type Constant struct{}
+
+// Duration returns min.
+func (s *Constant) Duration(min, max time.Duration, sleeps uint64) time.Duration {
+	return min
+}
+
+// Linear implements Strategy, increasing the sleep duration by Step on each
+// call. A Step of 0 is taken to mean Min.
+type Linear struct {
+	Step time.Duration
+}
+
+// Duration returns min plus Step for each previous sleep. max is not
+// considered.
+func (s *Linear) Duration(min, max time.Duration, sleeps uint64) time.Duration {
+	step := s.Step
+	if step == 0 {
+		step = min
+	}
+
+	return floatToDuration(float64(min) + float64(step)*float64(sleeps))
+}
+
+// Exponential implements Strategy, multiplying the sleep duration by Factor on
+// each call.
+type Exponential struct {
+	Factor float64
+}
+
+// Duration returns min multiplied by Factor for each previous sleep. max is not
+// considered.
+func (s *Exponential) Duration(min, max time.Duration, sleeps uint64) time.Duration {
+	return floatToDuration(float64(min) * math.Pow(s.Factor, float64(sleeps)))
+}
+
+// Fibonacci implements Strategy, sleeping for Min multiplied by successive
+// numbers of the Fibonacci sequence (1, 1, 2, 3, 5, 8...).
+type Fibonacci struct{}
+
+// Duration returns min multiplied by the Fibonacci number for sleeps+1. The
+// sequence is not calculated further than needed to exceed max.
+func (s *Fibonacci) Duration(min, max time.Duration, sleeps uint64) time.Duration {
+	a, b := 0.0, 1.0
+
+	for i := uint64(0); i < sleeps && b < math.MaxInt64 && float64(min)*b <= float64(max); i++ {
+		a, b = b, a+b
+	}
+
+	return floatToDuration(float64(min) * b)
+}
+
+// DecorrelatedJitter implements Strategy using the "decorrelated jitter"
+// algorithm described by AWS, where each sleep is a random duration between
+// Min and Factor times the previous sleep (capped at Max). A Factor of 0 is
+// taken to mean 3.
+//
+// Because its durations are already random, Backoff does not apply its own
+// jitter to them. It is concurrent safe, but should not be shared between
+// Backoffs.
+type DecorrelatedJitter struct {
+	Factor float64
+
+	// Rand is the source of randomness. If nil, the global math/rand functions
+	// are used.
+	Rand Random
+
+	prev time.Duration
+	mu   sync.Mutex
+}
+
+// Duration returns min for the first sleep, then a random duration between
+// min and Factor times the previously returned duration, but not more than max.
+func (s *DecorrelatedJitter) Duration(min, max time.Duration, sleeps uint64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sleeps == 0 || s.prev < min {
+		s.prev = min
+
+		return min
+	}
+
+	factor := s.Factor
+	if factor == 0 {
+		factor = decorrelatedFactor
+	}
+
+	upper := math.Min(float64(s.prev)*factor, float64(max))
+	if upper < float64(min) {
+		upper = float64(min)
+	}
+
+	s.prev = randomBetween(randomOrGlobal(s.Rand), min, floatToDuration(upper))
+
+	return s.prev
+}
+
+func (s *DecorrelatedJitter) selfJittering() {}
+
+// floatToDuration converts f to a Duration, saturating at the maximum possible
+// Duration instead of overflowing.
+func floatToDuration(f float64) time.Duration {
+	if f >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(f)
+}
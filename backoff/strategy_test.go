@@ -0,0 +1,144 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package backoff
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+func TestStrategy(t *testing.T) {
+	min := 1 * time.Millisecond
+	max := 100 * time.Millisecond
+
+	durations := func(s Strategy, n int) []time.Duration {
+		ds := make([]time.Duration, n)
+		for i := range ds {
+			ds[i] = s.Duration(min, max, uint64(i))
+		}
+
+		return ds
+	}
+
+	Convey("Constant always returns Min", t, func() {
+		var _ Strategy = (*Constant)(nil)
+		So(durations(&Constant{}, 4), ShouldResemble, []time.Duration{min, min, min, min})
+	})
+
+	Convey("Linear increases by Step", t, func() {
+		var _ Strategy = (*Linear)(nil)
+		So(durations(&Linear{Step: 2 * time.Millisecond}, 4), ShouldResemble,
+			[]time.Duration{1 * time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond, 7 * time.Millisecond})
+
+		Convey("Which defaults to Min", func() {
+			So(durations(&Linear{}, 3), ShouldResemble,
+				[]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+		})
+	})
+
+	Convey("Exponential multiplies by Factor", t, func() {
+		var _ Strategy = (*Exponential)(nil)
+		So(durations(&Exponential{Factor: 2}, 4), ShouldResemble,
+			[]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 8 * time.Millisecond})
+
+		Convey("Without overflowing", func() {
+			So((&Exponential{Factor: 2}).Duration(min, max, 100), ShouldEqual, time.Duration(math.MaxInt64))
+		})
+	})
+
+	Convey("Fibonacci follows the Fibonacci sequence", t, func() {
+		var _ Strategy = (*Fibonacci)(nil)
+		So(durations(&Fibonacci{}, 6), ShouldResemble, []time.Duration{
+			1 * time.Millisecond, 1 * time.Millisecond, 2 * time.Millisecond,
+			3 * time.Millisecond, 5 * time.Millisecond, 8 * time.Millisecond,
+		})
+
+		Convey("Without calculating far beyond max", func() {
+			So((&Fibonacci{}).Duration(min, max, math.MaxUint64), ShouldBeGreaterThan, max)
+			So((&Fibonacci{}).Duration(0, max, math.MaxUint64), ShouldEqual, 0)
+		})
+	})
+
+	Convey("DecorrelatedJitter returns random durations up to Factor times the previous", t, func() {
+		var _ Strategy = (*DecorrelatedJitter)(nil)
+		s := &DecorrelatedJitter{}
+		So(s.Duration(min, max, 0), ShouldEqual, min)
+
+		prev := min
+		for i := uint64(1); i < 10; i++ {
+			d := s.Duration(min, max, i)
+			So(d, ShouldBeBetweenOrEqual, min, 3*prev)
+			So(d, ShouldBeLessThanOrEqualTo, max)
+			prev = d
+		}
+
+		Convey("And starts again from Min", func() {
+			So(s.Duration(min, max, 0), ShouldEqual, min)
+		})
+	})
+
+	Convey("A Backoff can use a different Strategy", t, func() {
+		sleeper := &mock.Sleeper{}
+		b := &Backoff{
+			Min:      min,
+			Max:      3 * time.Millisecond,
+			Strategy: &Linear{},
+			Sleeper:  sleeper,
+		}
+
+		base := time.Now()
+		ctx := context.Background()
+
+		b.Sleep(ctx)
+		So(sleeper.Elapsed(), ShouldEqual, 1*time.Millisecond)
+
+		b.Sleep(ctx)
+		So(base.Add(sleeper.Elapsed()), ShouldHappenOnOrBetween, base.Add(2*time.Millisecond), base.Add(3*time.Millisecond))
+
+		b.Sleep(ctx)
+		b.Sleep(ctx)
+		So(base.Add(sleeper.Elapsed()), ShouldHappenOnOrBetween, base.Add(6*time.Millisecond), base.Add(9*time.Millisecond))
+
+		Convey("Self-jittering Strategies are not further jittered", func() {
+			sleeper = &mock.Sleeper{}
+			b = &Backoff{
+				Min:      min,
+				Max:      min,
+				Strategy: &DecorrelatedJitter{},
+				Sleeper:  sleeper,
+			}
+
+			b.Sleep(ctx)
+			b.Sleep(ctx)
+			So(sleeper.Elapsed(), ShouldEqual, 2*min)
+		})
+	})
+}
@@ -23,7 +23,8 @@
  * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
  ******************************************************************************/
 
-// package time contains a real time-based implementation of backoff.Sleeper.
+// package time contains a real time-based implementation of backoff.Sleeper
+// and backoff.Clock.
 package time
 
 import (
@@ -39,10 +40,15 @@ const (
 	secondsRangeFactor = 1.5
 )
 
-// Sleeper represents an implementation of backoff.Sleeper. It does an actual
-// sleep using time.Sleep.
+// Sleeper represents an implementation of backoff.Sleeper and backoff.Clock.
+// It does an actual sleep using time.Sleep.
 type Sleeper struct{}
 
+// Now returns the current time.
+func (s *Sleeper) Now() time.Time {
+	return time.Now()
+}
+
 // Sleep sleeps until the context is cancelled, or the given duration has
 // elapsed.
 func (s *Sleeper) Sleep(ctx context.Context, d time.Duration) {
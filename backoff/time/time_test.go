@@ -35,8 +35,14 @@ import (
 )
 
 func TestSleeper(t *testing.T) {
-	Convey("Sleeper implements backoff.Sleeper", t, func() {
+	Convey("Sleeper implements backoff.Sleeper and backoff.Clock", t, func() {
 		var _ backoff.Sleeper = (*Sleeper)(nil)
+		var _ backoff.Clock = (*Sleeper)(nil)
+	})
+
+	Convey("Sleeper.Now() returns the current time", t, func() {
+		tn := time.Now()
+		So((&Sleeper{}).Now(), ShouldHappenOnOrBetween, tn, time.Now())
 	})
 
 	Convey("Sleeper.Sleep() really sleeps", t, func() {
@@ -0,0 +1,280 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package breaker implements the circuit breaker pattern, to stop many callers
+// from hammering a dependency that is failing.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wtsi-ssg/wr/backoff"
+	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/retry"
+)
+
+// logger is used for all our logging, as the "breaker" subsystem.
+const logger clog.Logger = "breaker"
+
+// State is the type of our State* constants.
+type State string
+
+// State* constants are the states a Breaker can be in.
+const (
+	// StateClosed means calls are allowed through.
+	StateClosed State = "closed"
+
+	// StateOpen means calls are rejected until a cool-down period has passed.
+	StateOpen State = "open"
+
+	// StateHalfOpen means a single trial call is allowed through to see if the
+	// dependency has recovered.
+	StateHalfOpen State = "half-open"
+)
+
+// defaultCoolDownMin etc. are the settings of the Backoff used by Breakers that
+// don't have one.
+const (
+	defaultCoolDownMin    = 1 * time.Second
+	defaultCoolDownMax    = 1 * time.Minute
+	defaultCoolDownFactor = 2
+)
+
+// BecauseCircuitOpen is returned by Breaker.ShouldStop() when the circuit is
+// open.
+const BecauseCircuitOpen retry.Reason = "circuit open"
+
+// ErrOpen is returned by Operations wrapped with Breaker.Wrap() when the
+// circuit is open and so the Operation wasn't run.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Breaker is a circuit breaker. It starts closed, opens after Threshold
+// consecutive failures, and then after a cool-down period determined by Backoff
+// becomes half-open, allowing a single trial call. If the trial succeeds the
+// Breaker closes (and Backoff is Reset()); if it fails it opens again, for a
+// longer cool-down.
+//
+// It is concurrent safe, and is intended to be shared by everything that calls
+// the same dependency.
+type Breaker struct {
+	// Name identifies the Breaker in logs.
+	Name string
+
+	// Threshold is the number of consecutive failures that open the circuit. 0
+	// is treated as 1.
+	Threshold int
+
+	// Backoff determines the cool-down period of each opening of the circuit.
+	// It is never slept on, so its Sleeper is not used. If nil, cool-downs start
+	// at 1s and double each time, up to 1m.
+	Backoff *backoff.Backoff
+
+	// Clock is used to tell the time. If nil, real time is used.
+	Clock backoff.Clock
+
+	state     State
+	failures  int
+	openUntil time.Time
+	trialling bool
+	mu        sync.Mutex
+}
+
+// State returns the current State of the Breaker. An open Breaker whose
+// cool-down has passed is reported as half-open.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.currentState()
+}
+
+// currentState returns our State, taking account of the cool-down period. You
+// must hold the lock before calling this.
+func (b *Breaker) currentState() State {
+	switch {
+	case b.state == "":
+		return StateClosed
+	case b.state == StateOpen && !b.now().Before(b.openUntil):
+		return StateHalfOpen
+	default:
+		return b.state
+	}
+}
+
+// Allow returns true if a call should be made: always when closed, never when
+// open, and for only the first caller when half-open (until that caller reports
+// Success() or Failure()).
+func (b *Breaker) Allow(ctx context.Context) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case StateClosed:
+		return true
+	case StateHalfOpen:
+		if b.trialling {
+			return false
+		}
+
+		b.transition(ctx, StateHalfOpen)
+		b.trialling = true
+
+		return true
+	default:
+		return false
+	}
+}
+
+// Success reports that an allowed call succeeded, closing the circuit.
+func (b *Breaker) Success(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trialling = false
+
+	if b.currentState() != StateClosed {
+		b.backoff().Reset()
+		b.transition(ctx, StateClosed)
+	}
+}
+
+// Failure reports that an allowed call failed. The circuit opens if this was a
+// half-open trial, or Threshold consecutive failures have now occurred while
+// closed.
+func (b *Breaker) Failure(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	if b.trialling || (b.currentState() == StateClosed && b.failures >= b.threshold()) {
+		b.trialling = false
+		b.open(ctx)
+	}
+}
+
+// threshold returns our Threshold, treating 0 as 1.
+func (b *Breaker) threshold() int {
+	if b.Threshold < 1 {
+		return 1
+	}
+
+	return b.Threshold
+}
+
+// open opens the circuit for the next cool-down period from our Backoff. The
+// cool-down is not shortened to the deadline of the given context, since that
+// only belongs to the caller that happened to fail. You must hold the lock
+// before calling this.
+func (b *Breaker) open(ctx context.Context) {
+	coolDown := b.backoff().Next(context.Background(), time.Time{})
+	b.openUntil = b.now().Add(coolDown)
+	b.transition(ctx, StateOpen, "cooldown", coolDown)
+}
+
+// backoff returns our Backoff, first defaulting it if nil. You must hold the
+// lock before calling this.
+func (b *Breaker) backoff() *backoff.Backoff {
+	if b.Backoff == nil {
+		b.Backoff = &backoff.Backoff{
+			Min:    defaultCoolDownMin,
+			Max:    defaultCoolDownMax,
+			Factor: defaultCoolDownFactor,
+			Jitter: backoff.JitterNone,
+		}
+	}
+
+	return b.Backoff
+}
+
+// transition changes our state to the given one, logging the change. You must
+// hold the lock before calling this.
+func (b *Breaker) transition(ctx context.Context, to State, args ...interface{}) {
+	from := b.state
+	if from == "" {
+		from = StateClosed
+	}
+
+	b.state = to
+
+	args = append([]interface{}{"breaker", b.Name, "from", from, "to", to}, args...)
+
+	if to == StateOpen {
+		logger.Warn(ctx, "circuit breaker state change", args...)
+
+		return
+	}
+
+	logger.Info(ctx, "circuit breaker state change", args...)
+}
+
+// now returns the current time according to our Clock, or real time if we have
+// no Clock.
+func (b *Breaker) now() time.Time {
+	if b.Clock == nil {
+		return time.Now()
+	}
+
+	return b.Clock.Now()
+}
+
+// Wrap returns a retry.Operation that only runs op if Allow() returns true,
+// returning ErrOpen otherwise, and reports op's result with Success() or
+// Failure(). The given context is used for logging state changes.
+func (b *Breaker) Wrap(ctx context.Context, op retry.Operation) retry.Operation {
+	return func() error {
+		if !b.Allow(ctx) {
+			return ErrOpen
+		}
+
+		err := op()
+		if err != nil {
+			b.Failure(ctx)
+
+			return err
+		}
+
+		b.Success(ctx)
+
+		return nil
+	}
+}
+
+// ShouldStop implements retry.Until, returning BecauseCircuitOpen when the
+// circuit is open or err is ErrOpen. retries is not considered. Combine with
+// Wrap() so that attempts are recorded and blocked by the Breaker:
+//
+//	retry.Do(ctx, b.Wrap(ctx, op), retry.Untils{b, &retry.UntilNoError{}}, bo, activity)
+func (b *Breaker) ShouldStop(retries int, err error) retry.Reason {
+	if errors.Is(err, ErrOpen) || b.State() == StateOpen {
+		return BecauseCircuitOpen
+	}
+
+	return ""
+}
@@ -0,0 +1,192 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/retry"
+)
+
+var errOp = errors.New("op err")
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Breaker implements retry.Until", t, func() {
+		var _ retry.Until = (*Breaker)(nil)
+	})
+
+	Convey("Given a Breaker", t, func() {
+		clock := bm.NewClock(time.Now())
+		b := &Breaker{
+			Name:      "foo",
+			Threshold: 2,
+			Backoff: &backoff.Backoff{
+				Min:    1 * time.Second,
+				Max:    10 * time.Second,
+				Factor: 2,
+				Jitter: backoff.JitterNone,
+			},
+			Clock: clock,
+		}
+
+		So(b.State(), ShouldEqual, StateClosed)
+		So(b.Allow(ctx), ShouldBeTrue)
+
+		Convey("It opens after Threshold consecutive failures", func() {
+			buff := clog.ToBufferAtLevel("debug")
+			defer clog.ToDefault()
+
+			b.Failure(ctx)
+			So(b.State(), ShouldEqual, StateClosed)
+			b.Success(ctx)
+			b.Failure(ctx)
+			So(b.State(), ShouldEqual, StateClosed)
+			b.Failure(ctx)
+			So(b.State(), ShouldEqual, StateOpen)
+			So(b.Allow(ctx), ShouldBeFalse)
+
+			lmsg := buff.String()
+			So(lmsg, ShouldContainSubstring, "lvl=warn")
+			So(lmsg, ShouldContainSubstring, `msg="circuit breaker state change"`)
+			So(lmsg, ShouldContainSubstring, "breaker=foo from=closed to=open cooldown=1s")
+
+			Convey("Regardless of the deadline of the failing caller's context", func() {
+				b.Success(ctx)
+				So(b.State(), ShouldEqual, StateClosed)
+
+				deadlineCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+
+				b.Failure(deadlineCtx)
+				b.Failure(deadlineCtx)
+				So(b.State(), ShouldEqual, StateOpen)
+				So(buff.String(), ShouldContainSubstring, "breaker=foo from=closed to=open cooldown=1s")
+				So(buff.String(), ShouldNotContainSubstring, "cooldown=9")
+
+				clock.Advance(999 * time.Millisecond)
+				So(b.State(), ShouldEqual, StateOpen)
+			})
+
+			Convey("Then half-opens after the cool-down, allowing one trial", func() {
+				clock.Advance(1 * time.Second)
+				So(b.State(), ShouldEqual, StateHalfOpen)
+				So(b.Allow(ctx), ShouldBeTrue)
+				So(b.Allow(ctx), ShouldBeFalse)
+				So(buff.String(), ShouldContainSubstring, "from=open to=half-open")
+
+				Convey("Which closes it on success", func() {
+					b.Success(ctx)
+					So(b.State(), ShouldEqual, StateClosed)
+					So(b.Allow(ctx), ShouldBeTrue)
+					So(buff.String(), ShouldContainSubstring, "from=half-open to=closed")
+
+					b.Failure(ctx)
+					b.Failure(ctx)
+					clock.Advance(1 * time.Second)
+					So(b.State(), ShouldEqual, StateHalfOpen)
+				})
+
+				Convey("Or re-opens it for longer on failure", func() {
+					b.Failure(ctx)
+					So(b.State(), ShouldEqual, StateOpen)
+					clock.Advance(1 * time.Second)
+					So(b.State(), ShouldEqual, StateOpen)
+					clock.Advance(1 * time.Second)
+					So(b.State(), ShouldEqual, StateHalfOpen)
+				})
+			})
+		})
+
+		Convey("Wrap() blocks Operations and records their results", func() {
+			count := 0
+			op := b.Wrap(ctx, func() error {
+				count++
+
+				return errOp
+			})
+
+			So(op(), ShouldEqual, errOp)
+			So(op(), ShouldEqual, errOp)
+			So(op(), ShouldEqual, ErrOpen)
+			So(count, ShouldEqual, 2)
+
+			Convey("And it stops retry.Do immediately when open", func() {
+				bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+				status := retry.Do(ctx, op, retry.Untils{b, &retry.UntilLimit{Max: 5}}, bo, "doing foo")
+				So(status.Retried, ShouldEqual, 0)
+				So(status.StoppedBecause, ShouldEqual, BecauseCircuitOpen)
+				So(status.Err, ShouldEqual, ErrOpen)
+				So(count, ShouldEqual, 2)
+			})
+		})
+
+		Convey("retry.Do stops as soon as the circuit opens", func() {
+			count := 0
+			op := b.Wrap(ctx, func() error {
+				count++
+
+				return errOp
+			})
+			bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+
+			status := retry.Do(ctx, op, retry.Untils{b, &retry.UntilLimit{Max: 5}}, bo, "doing foo")
+			So(status.Retried, ShouldEqual, 1)
+			So(status.StoppedBecause, ShouldEqual, BecauseCircuitOpen)
+			So(status.Err, ShouldEqual, errOp)
+			So(count, ShouldEqual, 2)
+		})
+	})
+
+	Convey("A Breaker without a Backoff uses a default cool-down", t, func() {
+		clock := bm.NewClock(time.Now())
+		b := &Breaker{Clock: clock}
+
+		b.Failure(ctx)
+		So(b.State(), ShouldEqual, StateOpen)
+
+		clock.Advance(defaultCoolDownMin)
+		So(b.State(), ShouldEqual, StateHalfOpen)
+		So(b.Allow(ctx), ShouldBeTrue)
+
+		b.Failure(ctx)
+		clock.Advance(defaultCoolDownMin)
+		So(b.State(), ShouldEqual, StateOpen)
+		clock.Advance(defaultCoolDownMin)
+		So(b.State(), ShouldEqual, StateHalfOpen)
+
+		b.Success(ctx)
+		So(b.State(), ShouldEqual, StateClosed)
+	})
+}
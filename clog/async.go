@@ -0,0 +1,318 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// OverflowPolicy is the type of our Overflow* constants, which determine what
+// an asynchronous Sink does when its queue is full.
+type OverflowPolicy string
+
+// Overflow* constants are the policies supported by Sink.Async().
+const (
+	// OverflowBlock makes logging calls wait until there is space in the
+	// queue, so no messages are lost.
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropNewest discards the message being logged.
+	OverflowDropNewest OverflowPolicy = "drop newest"
+
+	// OverflowDropOldest discards the oldest queued message to make space for
+	// the one being logged.
+	OverflowDropOldest OverflowPolicy = "drop oldest"
+)
+
+// Async makes the Sink asynchronous: logging calls add records to a queue
+// that holds up to size records, and return without waiting for them to be
+// written, which happens in the background. This stops slow outputs, like a
+// file on a network file system, from slowing down the code that logs. When
+// the queue is full, policy determines what happens. Returns the Sink.
+//
+// Call Flush() before exiting to make sure queued records are written.
+// Removing the Sink waits for queued records to be written. Calling Async()
+// on an already asynchronous Sink does nothing.
+func (s *Sink) Async(size int, policy OverflowPolicy) *Sink {
+	sinks.Lock()
+	defer sinks.Unlock()
+
+	if s.async != nil {
+		return s
+	}
+
+	s.async = newAsyncHandler(s.handler, size, policy)
+	s.handler = s.async
+
+	return s
+}
+
+// Dropped returns the number of records that an asynchronous Sink has
+// discarded because its queue was full. It is always 0 for other Sinks.
+func (s *Sink) Dropped() uint64 {
+	if a := s.asyncHandler(); a != nil {
+		return a.Dropped()
+	}
+
+	return 0
+}
+
+// Flush waits until all the records queued by an asynchronous Sink have been
+// written, or the context is done, in which case the context's error is
+// returned. It returns immediately for other Sinks.
+func (s *Sink) Flush(ctx context.Context) error {
+	if a := s.asyncHandler(); a != nil {
+		return a.Flush(ctx)
+	}
+
+	return nil
+}
+
+// asyncHandler returns our asyncHandler, if we are asynchronous.
+func (s *Sink) asyncHandler() *asyncHandler {
+	sinks.RLock()
+	defer sinks.RUnlock()
+
+	return s.async
+}
+
+// Flush logs the summaries of any messages currently being deduplicated (see
+// Sampling), then calls Flush() on all the current Sinks of the global logger.
+// Returns the first error from a Sink's Flush(), or else from logging the
+// summaries. Call it before exiting.
+func Flush(ctx context.Context) error {
+	summariesErr := flushSummaries()
+
+	sinks.RLock()
+	list := make([]*Sink, len(sinks.list))
+	copy(list, sinks.list)
+	sinks.RUnlock()
+
+	for _, s := range list {
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	return summariesErr
+}
+
+// asyncHandler is a log.Handler that queues records in a ring buffer, for a
+// background goroutine to pass to another handler.
+type asyncHandler struct {
+	next    log.Handler
+	policy  OverflowPolicy
+	dropped uint64
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	ring    []*log.Record
+	head    int
+	count   int
+	busy    bool
+	closed  bool
+	done    chan struct{}
+}
+
+// newAsyncHandler returns an asyncHandler with a queue of the given size (at
+// least 1) that passes records to next, and starts its background goroutine.
+func newAsyncHandler(next log.Handler, size int, policy OverflowPolicy) *asyncHandler {
+	if size < 1 {
+		size = 1
+	}
+
+	a := &asyncHandler{
+		next:   next,
+		policy: policy,
+		ring:   make([]*log.Record, size),
+		done:   make(chan struct{}),
+	}
+	a.changed = sync.NewCond(&a.mu)
+
+	go a.run()
+
+	return a
+}
+
+// Log implements log.Handler, queuing the record according to our policy.
+// Records logged after we are closed are discarded.
+func (a *asyncHandler) Log(r *log.Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.makeSpace() {
+		return nil
+	}
+
+	a.ring[(a.head+a.count)%len(a.ring)] = r
+	a.count++
+	a.changed.Broadcast()
+
+	return nil
+}
+
+// makeSpace makes sure there is space in our queue according to our policy,
+// returning false if the record being logged should be discarded instead,
+// because our policy is OverflowDropNewest or we have been closed. You must
+// hold our lock.
+func (a *asyncHandler) makeSpace() bool {
+	for a.count == len(a.ring) && !a.closed {
+		switch a.policy {
+		case OverflowDropNewest:
+			atomic.AddUint64(&a.dropped, 1)
+
+			return false
+		case OverflowDropOldest:
+			a.pop()
+			atomic.AddUint64(&a.dropped, 1)
+		default:
+			a.changed.Wait()
+		}
+	}
+
+	return !a.closed
+}
+
+// pop removes and returns the oldest queued record. You must hold our lock and
+// have checked that count is greater than 0.
+func (a *asyncHandler) pop() *log.Record {
+	r := a.ring[a.head]
+	a.ring[a.head] = nil
+	a.head = (a.head + 1) % len(a.ring)
+	a.count--
+
+	return r
+}
+
+// run passes queued records to our next handler until we are closed and the
+// queue is empty. Since there is no caller to return errors to, they are
+// written to STDERR.
+func (a *asyncHandler) run() {
+	defer close(a.done)
+
+	for {
+		r := a.take()
+		if r == nil {
+			return
+		}
+
+		if err := a.next.Log(r); err != nil {
+			fmt.Fprintf(os.Stderr, "clog: asynchronous sink failed to log: %s\n", err)
+		}
+
+		a.mu.Lock()
+		a.busy = false
+		a.changed.Broadcast()
+		a.mu.Unlock()
+	}
+}
+
+// take waits for a queued record and removes it from the queue, marking us
+// busy. Returns nil if we are closed and the queue is empty.
+func (a *asyncHandler) take() *log.Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.count == 0 && !a.closed {
+		a.changed.Wait()
+	}
+
+	if a.count == 0 {
+		return nil
+	}
+
+	a.busy = true
+	r := a.pop()
+	a.changed.Broadcast()
+
+	return r
+}
+
+// Dropped returns the number of records discarded because our queue was full.
+func (a *asyncHandler) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Flush waits until our queue is empty and our next handler has finished with
+// the last record, or the context is done.
+func (a *asyncHandler) Flush(ctx context.Context) error {
+	stop := a.wakeWhenDone(ctx)
+	defer stop()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.pending() && ctx.Err() == nil {
+		a.changed.Wait()
+	}
+
+	if a.pending() {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// wakeWhenDone wakes up anything waiting on our changed condition when the
+// context is done. Call the returned function to stop.
+func (a *asyncHandler) wakeWhenDone(ctx context.Context) (stop func()) {
+	stopCh := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.mu.Lock()
+			a.changed.Broadcast()
+			a.mu.Unlock()
+		case <-stopCh:
+		}
+	}()
+
+	return func() { close(stopCh) }
+}
+
+// pending returns true if we have queued records, or our next handler is busy
+// with one. You must hold our lock.
+func (a *asyncHandler) pending() bool {
+	return a.count > 0 || a.busy
+}
+
+// close stops us accepting records, and waits for the queued ones to be
+// written.
+func (a *asyncHandler) close() {
+	a.mu.Lock()
+	a.closed = true
+	a.changed.Broadcast()
+	a.mu.Unlock()
+
+	<-a.done
+}
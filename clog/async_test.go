@@ -0,0 +1,242 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// gatedWriter is an io.Writer that blocks writes until released.
+type gatedWriter struct {
+	gate chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+	buff bytes.Buffer
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{gate: make(chan struct{})}
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	<-g.gate
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.buff.Write(p)
+}
+
+func (g *gatedWriter) release() {
+	g.once.Do(func() { close(g.gate) })
+}
+
+func (g *gatedWriter) String() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.buff.String()
+}
+
+func TestAsync(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("With logging to a buffer and a slow asynchronous Sink", t, func() {
+		buff := ToBufferAtLevel("debug")
+		slow := newGatedWriter()
+		s := AddSink(slow, "debug", FormatLogfmt)
+		So(s.Async(2, OverflowDropNewest), ShouldEqual, s)
+		So(s.Async(5, OverflowBlock), ShouldEqual, s)
+
+		Reset(func() {
+			slow.release()
+			ToDefault()
+		})
+
+		Convey("Logging doesn't wait for the slow writer", func() {
+			Info(ctx, "msg1")
+			So(buff.String(), ShouldContainSubstring, "msg1")
+			So(slow.String(), ShouldBeBlank)
+
+			Convey("Flush waits for queued records to be written", func() {
+				timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				So(errors.Is(s.Flush(timeout), context.DeadlineExceeded), ShouldBeTrue)
+				So(errors.Is(Flush(timeout), context.DeadlineExceeded), ShouldBeTrue)
+
+				slow.release()
+				So(Flush(ctx), ShouldBeNil)
+				So(slow.String(), ShouldContainSubstring, "msg1")
+				So(s.Dropped(), ShouldEqual, 0)
+			})
+
+			Convey("Removing the Sink writes queued records", func() {
+				Info(ctx, "msg2")
+				slow.release()
+				So(RemoveSink(s), ShouldBeNil)
+				So(slow.String(), ShouldContainSubstring, "msg2")
+
+				Info(ctx, "msg3")
+				So(buff.String(), ShouldContainSubstring, "msg3")
+				So(slow.String(), ShouldNotContainSubstring, "msg3")
+			})
+
+			Convey("Logging isn't blocked while removing a stuck Sink", func() {
+				removed := make(chan error, 1)
+				go func() { removed <- RemoveSink(s) }()
+				<-time.After(10 * time.Millisecond)
+
+				logged := make(chan struct{})
+				go func() {
+					Info(ctx, "msg3")
+					close(logged)
+				}()
+
+				unblocked := false
+				select {
+				case <-logged:
+					unblocked = true
+				case <-time.After(time.Second):
+				}
+
+				So(unblocked, ShouldBeTrue)
+				So(buff.String(), ShouldContainSubstring, "msg3")
+				slow.release()
+				So(<-removed, ShouldBeNil)
+				<-logged
+			})
+		})
+
+		Convey("When the queue is full, new records are dropped and counted", func() {
+			logUntilWriterBusy(ctx, s, slow)
+
+			for _, msg := range []string{"q1", "q2", "q3", "q4"} {
+				Info(ctx, msg)
+			}
+
+			So(s.Dropped(), ShouldEqual, 2)
+			slow.release()
+			So(s.Flush(ctx), ShouldBeNil)
+			So(slow.String(), ShouldContainSubstring, "q1")
+			So(slow.String(), ShouldContainSubstring, "q2")
+			So(slow.String(), ShouldNotContainSubstring, "q3")
+			So(slow.String(), ShouldNotContainSubstring, "q4")
+		})
+	})
+
+	Convey("With a slow asynchronous Sink that drops the oldest records", t, func() {
+		slow := newGatedWriter()
+		s := AddSink(slow, "debug", FormatLogfmt).Async(2, OverflowDropOldest)
+
+		Reset(func() {
+			slow.release()
+			ToDefault()
+		})
+
+		logUntilWriterBusy(ctx, s, slow)
+
+		for _, msg := range []string{"q1", "q2", "q3", "q4"} {
+			Info(ctx, msg)
+		}
+
+		So(s.Dropped(), ShouldEqual, 2)
+		slow.release()
+		So(s.Flush(ctx), ShouldBeNil)
+		So(slow.String(), ShouldNotContainSubstring, "q1")
+		So(slow.String(), ShouldNotContainSubstring, "q2")
+		So(slow.String(), ShouldContainSubstring, "q3")
+		So(slow.String(), ShouldContainSubstring, "q4")
+	})
+
+	Convey("With a slow asynchronous Sink that blocks", t, func() {
+		slow := newGatedWriter()
+		s := AddSink(slow, "debug", FormatLogfmt).Async(1, OverflowBlock)
+
+		Reset(func() {
+			slow.release()
+			ToDefault()
+		})
+
+		logUntilWriterBusy(ctx, s, slow)
+		Info(ctx, "q1")
+
+		logged := make(chan bool)
+
+		go func() {
+			Info(ctx, "q2")
+			close(logged)
+		}()
+
+		<-time.After(10 * time.Millisecond)
+		select {
+		case <-logged:
+			So(false, ShouldBeTrue)
+		default:
+		}
+
+		slow.release()
+		<-logged
+		So(s.Flush(ctx), ShouldBeNil)
+		So(s.Dropped(), ShouldEqual, 0)
+		So(strings.Count(slow.String(), "msg=q"), ShouldEqual, 2)
+	})
+
+	Convey("Synchronous Sinks have nothing to flush or drop", t, func() {
+		s := AddSink(new(bytes.Buffer), "debug", FormatLogfmt)
+		defer ToDefault()
+
+		So(s.Flush(ctx), ShouldBeNil)
+		So(s.Dropped(), ShouldEqual, 0)
+	})
+}
+
+// logUntilWriterBusy logs a record and waits until the asynchronous Sink has
+// taken it off its queue and is blocked writing it to slow.
+func logUntilWriterBusy(ctx context.Context, s *Sink, slow *gatedWriter) {
+	Info(ctx, "busy")
+
+	a := s.asyncHandler()
+
+	for {
+		a.mu.Lock()
+		busy := a.busy
+		a.mu.Unlock()
+
+		if busy {
+			return
+		}
+
+		<-time.After(time.Millisecond)
+	}
+}
@@ -30,51 +30,71 @@ package clog
 import (
 	"bytes"
 	"context"
+	"io"
+	"os"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/sb10/l15h"
+	"github.com/wtsi-ssg/wr/tracing"
 )
 
-// init sets our default logging syle.
+// init sets our default logging syle, and any subsystem levels set in the
+// WR_LOG_LEVELS environment variable.
 func init() {
+	log.Root().SetHandler(l15h.CallerInfoHandler(log.FuncHandler(logToSinks)))
 	ToDefault()
+
+	if err := SetLevels(os.Getenv(levelsEnvVar)); err != nil {
+		Warn(context.Background(), "ignoring "+levelsEnvVar, "err", err)
+	}
 }
 
-// ToDefault sets the global logger to log to STDERR at the "warn" level.
+// ToDefault sets the global logger to log to STDERR at the "warn" level,
+// removing any other Sinks.
 func ToDefault() {
-	toOutputAtLevel(log.StderrHandler, log.LvlWarn)
+	toOutputAtLevel(log.StderrHandler, log.LvlWarn, nil)
+}
+
+// toOutputAtLevel makes a Sink that filters on the given level and outputs to
+// the given handler the only Sink of the global logger. Caller info is added
+// to records before they reach any Sink. If closer is not nil, it will be
+// closed when the Sink is removed.
+func toOutputAtLevel(outputHandler log.Handler, lvl log.Lvl, closer io.Closer) {
+	setSink(newSink(outputHandler, lvl, closer))
 }
 
-// toOutputAtLevel sets the handler of the global logger to filter on the given
-// level, add caller info, and output to the given handler.
-func toOutputAtLevel(outputHandler log.Handler, lvl log.Lvl) {
-	h := log.LvlFilterHandler(
-		lvl,
-		l15h.CallerInfoHandler(
-			outputHandler,
-		),
-	)
-	log.Root().SetHandler(h)
+// To sets the global logger to log to the given writer at the given level, in
+// the given format, removing any other Sinks.
+func To(w io.Writer, lvl string, format Format) {
+	toOutputAtLevel(log.StreamHandler(w, format.log15Format()), lvlFromString(lvl), nil)
 }
 
 // ToBufferAtLevel sets the global logger to log to the returned
-// bytes.Buffer at the given level.
-func ToBufferAtLevel(lvl string) *bytes.Buffer {
+// bytes.Buffer at the given level, removing any other Sinks. Logs are in
+// FormatLogfmt, unless you supply a different Format.
+func ToBufferAtLevel(lvl string, format ...Format) *bytes.Buffer {
 	buff := new(bytes.Buffer)
-	toOutputAtLevel(log.StreamHandler(buff, log.LogfmtFormat()), lvlFromString(lvl))
+	To(buff, lvl, formatOrDefault(format))
 
 	return buff
 }
 
 // ToFileAtLevel sets the global logger to log to a file at the given path
-// and at the given level.
-func ToFileAtLevel(path, lvl string) error {
-	fh, err := log.FileHandler(path, log.LogfmtFormat())
+// and at the given level, removing any other Sinks. Logs are in FormatLogfmt,
+// unless you supply a different Format.
+func ToFileAtLevel(path, lvl string, format ...Format) error {
+	return ToRotatingFileAtLevel(path, lvl, Rotation{}, format...)
+}
+
+// ToRotatingFileAtLevel is like ToFileAtLevel(), but the file is rotated
+// according to the given Rotation.
+func ToRotatingFileAtLevel(path, lvl string, rotation Rotation, format ...Format) error {
+	fh, closer, err := fileHandler(path, formatOrDefault(format), rotation)
 	if err != nil {
 		return err
 	}
 
-	toOutputAtLevel(fh, lvlFromString(lvl))
+	toOutputAtLevel(fh, lvlFromString(lvl), closer)
 
 	return nil
 }
@@ -98,6 +118,11 @@ func logger(ctx context.Context) log.Logger {
 		logger = addStringKeyToLogger(ctx, logger, retrySetKey, "retryset")
 		logger = addStringKeyToLogger(ctx, logger, retryActivityKey, "retryactivity")
 		logger = addIntKeyToLogger(ctx, logger, retryNumKey, "retrynum")
+		logger = addSpanToLogger(ctx, logger)
+
+		if fields := fieldsFromContext(ctx); len(fields) > 0 {
+			logger = logger.New(fields...)
+		}
 	}
 
 	return logger
@@ -123,6 +148,16 @@ func addIntKeyToLogger(ctx context.Context, logger log.Logger, key correlationID
 	return logger
 }
 
+// addSpanToLogger checks if a tracing.Span is set in the context and returns a
+// new logger with its trace and span IDs if so.
+func addSpanToLogger(ctx context.Context, logger log.Logger) log.Logger {
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		logger = logger.New("traceid", span.TraceID.String(), "spanid", span.SpanID.String())
+	}
+
+	return logger
+}
+
 // Debug logs the given message with context and args to the global logger at
 // the debug level. Caller info is included.
 func Debug(ctx context.Context, msg string, args ...interface{}) {
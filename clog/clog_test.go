@@ -26,12 +26,15 @@
 package clog
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/inconshreveable/log15"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/wtsi-ssg/wr/internal"
+	"github.com/wtsi-ssg/wr/tracing"
 )
 
 func TestLogger(t *testing.T) {
@@ -62,6 +65,19 @@ func TestLogger(t *testing.T) {
 		So(buff.String(), ShouldContainSubstring, "retrynum=3")
 	})
 
+	Convey("Tracing span context gets logged", t, func() {
+		buff := ToBufferAtLevel("debug")
+		defer ToDefault()
+		tracing.SetExporter(&tracing.InMemoryExporter{})
+		defer tracing.SetExporter(nil)
+
+		ctx, span := tracing.Start(background, "foo")
+		defer span.End()
+
+		Debug(ctx, "msg", "foo", 1)
+		So(buff.String(), ShouldContainSubstring, "traceid="+span.TraceID.String()+" spanid="+span.SpanID.String())
+	})
+
 	Convey("With logging set to a buffer at warn level, and some context", t, func() {
 		buff := ToBufferAtLevel("warn")
 		retryNum := 3
@@ -157,6 +173,63 @@ func TestLogger(t *testing.T) {
 		})
 	})
 
+	Convey("You can log in different formats", t, func() {
+		ctx := ContextWithRetryNum(background, 3)
+
+		Convey("JSON", func() {
+			buff := ToBufferAtLevel("debug", FormatJSON)
+			Warn(ctx, "msg", "foo", 1)
+
+			var record map[string]interface{}
+			err := json.Unmarshal(buff.Bytes(), &record)
+			So(err, ShouldBeNil)
+			So(record["msg"], ShouldEqual, "msg")
+			So(record["lvl"], ShouldEqual, "warn")
+			So(record["foo"], ShouldEqual, 1)
+			So(record["retrynum"], ShouldEqual, 3)
+			So(record["caller"], ShouldStartWith, "clog")
+		})
+
+		Convey("Terminal", func() {
+			buff := ToBufferAtLevel("debug", FormatTerminal)
+			Warn(ctx, "msg", "foo", 1)
+			So(buff.String(), ShouldStartWith, "\x1b[33mWARN\x1b[0m[")
+			So(buff.String(), ShouldContainSubstring, "] msg ")
+		})
+
+		Convey("logfmt, which is also the default for unknown formats", func() {
+			buff := ToBufferAtLevel("debug", FormatLogfmt)
+			Warn(ctx, "msg", "foo", 1)
+			So(buff.String(), ShouldContainSubstring, "lvl=warn msg=msg")
+
+			buff = ToBufferAtLevel("debug", Format("foo"))
+			Warn(ctx, "msg", "foo", 1)
+			So(buff.String(), ShouldContainSubstring, "lvl=warn msg=msg")
+		})
+
+		Convey("To any writer", func() {
+			buff := new(bytes.Buffer)
+			To(buff, "info", FormatJSON)
+			Debug(ctx, "msg")
+			So(buff.String(), ShouldBeBlank)
+			Info(ctx, "msg")
+			So(buff.String(), ShouldStartWith, "{")
+		})
+
+		Convey("To a file", func() {
+			logPath := internal.FilePathInTempDir(t, "clog.json")
+
+			err := ToFileAtLevel(logPath, "debug", FormatJSON)
+			So(err, ShouldBeNil)
+			Debug(background, "msg")
+			So(internal.FileAsString(logPath), ShouldContainSubstring, `"msg":"msg"`)
+		})
+
+		Reset(func() {
+			ToDefault()
+		})
+	})
+
 	Convey("You can log to a file", t, func() {
 		logPath := internal.FilePathInTempDir(t, "clog.log")
 
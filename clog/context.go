@@ -28,6 +28,7 @@ package clog
 
 import (
 	"context"
+	"fmt"
 )
 
 // correlationIDType is for the *Key constants, which provide private quick-to-
@@ -38,6 +39,7 @@ const (
 	retrySetKey correlationIDType = iota
 	retryActivityKey
 	retryNumKey
+	fieldsKey
 )
 
 // ContextForRetries returns a context which knows a new unique retryset
@@ -50,7 +52,64 @@ func ContextForRetries(ctx context.Context, activity string) context.Context {
 	)
 }
 
+// RetrySet returns the retryset ID of a context returned by
+// ContextForRetries(), or blank if it doesn't have one.
+func RetrySet(ctx context.Context) string {
+	id, _ := ctx.Value(retrySetKey).(string)
+
+	return id
+}
+
 // ContextWithRetryNum returns a context which knows the given retrynum.
 func ContextWithRetryNum(ctx context.Context, retrynum int) context.Context {
 	return context.WithValue(ctx, retryNumKey, retrynum)
 }
+
+// ContextWith returns a context which knows the given fields, in addition to
+// any it already knew, so that every log message logged with the returned
+// context includes them. keyvals are alternating keys and values, like the
+// args to Debug() and friends, eg.
+//
+//	ctx = ContextWith(ctx, "job", jobKey, "schedulergroup", group)
+//
+// Fields are logged in the order they were first added. Adding a key that is
+// already known replaces its value without changing its position. A final key
+// without a value gets a nil value.
+func ContextWith(ctx context.Context, keyvals ...interface{}) context.Context {
+	existing := fieldsFromContext(ctx)
+	fields := make([]interface{}, len(existing), len(existing)+len(keyvals)+1)
+	copy(fields, existing)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{}
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+
+		fields = setField(fields, fmt.Sprint(keyvals[i]), val)
+	}
+
+	return context.WithValue(ctx, fieldsKey, fields)
+}
+
+// setField sets the value of the given key in the given alternating keys and
+// values, appending the key if it isn't already present.
+func setField(fields []interface{}, key string, val interface{}) []interface{} {
+	for i := 0; i < len(fields); i += 2 {
+		if fields[i] == key {
+			fields[i+1] = val
+
+			return fields
+		}
+	}
+
+	return append(fields, key, val)
+}
+
+// fieldsFromContext returns the alternating keys and values added to the given
+// context with ContextWith(), if any.
+func fieldsFromContext(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsKey).([]interface{})
+
+	return fields
+}
@@ -65,4 +65,38 @@ func TestContext(t *testing.T) {
 		So(isInt, ShouldBeTrue)
 		So(num, ShouldEqual, retrynum)
 	})
+
+	Convey("ContextWith returns a context with fields in the order added", t, func() {
+		ctx := ContextWith(background, "job", "abc", "group", 2)
+		So(fieldsFromContext(ctx), ShouldResemble, []interface{}{"job", "abc", "group", 2})
+
+		Convey("Which accumulate, with duplicates overriding", func() {
+			ctx2 := ContextWith(ctx, "user", "bob", "job", "def")
+			So(fieldsFromContext(ctx2), ShouldResemble, []interface{}{"job", "def", "group", 2, "user", "bob"})
+			So(fieldsFromContext(ctx), ShouldResemble, []interface{}{"job", "abc", "group", 2})
+		})
+
+		Convey("Keys are stringified and missing values are nil", func() {
+			ctx2 := ContextWith(ctx, 1, "one", "novalue")
+			So(fieldsFromContext(ctx2), ShouldResemble, []interface{}{"job", "abc", "group", 2, "1", "one", "novalue", nil})
+		})
+
+		Convey("And they get logged along with retry context", func() {
+			buff := ToBufferAtLevel("debug")
+			defer ToDefault()
+
+			Info(ContextWithRetryNum(ctx, 3), "msg", "foo", 1)
+			So(buff.String(), ShouldContainSubstring, "msg=msg retrynum=3 job=abc group=2 foo=1")
+		})
+	})
+
+	Convey("Contexts without fields have none", t, func() {
+		So(fieldsFromContext(background), ShouldBeNil)
+	})
+
+	Convey("RetrySet returns the retryset of a context", t, func() {
+		So(RetrySet(background), ShouldBeBlank)
+		ctx := ContextForRetries(background, "doing foo")
+		So(RetrySet(ctx), ShouldEqual, ctx.Value(retrySetKey))
+	})
 }
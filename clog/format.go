@@ -0,0 +1,71 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	log "github.com/inconshreveable/log15"
+)
+
+// Format is the type of our Format* constants, which determine how log records
+// are written out.
+type Format string
+
+// Format* constants are the formats supported by To() and the other output
+// functions.
+const (
+	// FormatLogfmt writes records as key=value pairs on a single line.
+	FormatLogfmt Format = "logfmt"
+
+	// FormatJSON writes each record as a JSON object on its own line.
+	FormatJSON Format = "json"
+
+	// FormatTerminal writes records in a colour-coded human readable form,
+	// suitable for a developer's console.
+	FormatTerminal Format = "terminal"
+)
+
+// log15Format returns the log15 Format corresponding to this Format. Unknown
+// Formats return the logfmt format.
+func (f Format) log15Format() log.Format {
+	switch f {
+	case FormatJSON:
+		return log.JsonFormat()
+	case FormatTerminal:
+		return log.TerminalFormat()
+	default:
+		return log.LogfmtFormat()
+	}
+}
+
+// formatOrDefault returns the first of the given formats, or FormatLogfmt if
+// none were supplied.
+func formatOrDefault(formats []Format) Format {
+	if len(formats) == 0 {
+		return FormatLogfmt
+	}
+
+	return formats[0]
+}
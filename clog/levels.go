@@ -0,0 +1,252 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// subsystemKey is the key under which a Logger's subsystem name is logged.
+const subsystemKey = "subsystem"
+
+// levelsEnvVar is the environment variable that SetLevels() is called with
+// when this package is initialised.
+const levelsEnvVar = "WR_LOG_LEVELS"
+
+// ErrBadLevels is returned by SetLevels() and friends when given an invalid
+// level or specification.
+var ErrBadLevels = errors.New("invalid log levels")
+
+// Logger logs like the package-level Debug() and friends, but as a named
+// subsystem, whose level can be changed at runtime independently of the
+// levels of the Sinks, using SetLevel(). A Logger is just the subsystem name,
+// so packages can declare theirs as a constant:
+//
+//	const logger clog.Logger = "retry"
+type Logger string
+
+// Named returns a Logger for the given subsystem, eg. "retry". Messages it logs
+// include the subsystem name.
+func Named(subsystem string) Logger {
+	return Logger(subsystem)
+}
+
+// Debug is like the package-level Debug(), but logs as our subsystem.
+func (l Logger) Debug(ctx context.Context, msg string, args ...interface{}) {
+	logger(ctx).Debug(msg, l.withSubsystem(args)...)
+}
+
+// Info is like the package-level Info(), but logs as our subsystem.
+func (l Logger) Info(ctx context.Context, msg string, args ...interface{}) {
+	logger(ctx).Info(msg, l.withSubsystem(args)...)
+}
+
+// Warn is like the package-level Warn(), but logs as our subsystem.
+func (l Logger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	logger(ctx).Warn(msg, l.withSubsystem(args)...)
+}
+
+// Error is like the package-level Error(), but logs as our subsystem.
+func (l Logger) Error(ctx context.Context, msg string, args ...interface{}) {
+	logger(ctx).Error(msg, l.withSubsystem(args)...)
+}
+
+// Crit is like the package-level Crit(), but logs as our subsystem.
+func (l Logger) Crit(ctx context.Context, msg string, args ...interface{}) {
+	logger(ctx).Crit(msg, l.withSubsystem(args)...)
+}
+
+// withSubsystem returns the given args with our subsystem name appended.
+func (l Logger) withSubsystem(args []interface{}) []interface{} {
+	return append(args[:len(args):len(args)], subsystemKey, string(l))
+}
+
+// levels holds the levels set for subsystems.
+var levels struct { //nolint:gochecknoglobals // subsystem levels are process-wide
+	sync.RWMutex
+	m map[string]log.Lvl
+}
+
+// SetLevel sets the level of the given subsystem. Messages logged by Named()
+// Loggers for that subsystem are then filtered on this level instead of the
+// levels of the Sinks, so that eg. debug messages of the subsystem can be seen
+// in a Sink that otherwise only shows warnings. Valid lvls are as for
+// ToBufferAtLevel().
+func SetLevel(subsystem, lvl string) error {
+	logLevel, err := parseLevel(lvl)
+	if err != nil {
+		return err
+	}
+
+	levels.Lock()
+	defer levels.Unlock()
+
+	if levels.m == nil {
+		levels.m = make(map[string]log.Lvl)
+	}
+
+	levels.m[subsystem] = logLevel
+
+	return nil
+}
+
+// ClearLevel undoes SetLevel() for the given subsystem, so that its messages
+// are filtered on the levels of the Sinks again.
+func ClearLevel(subsystem string) {
+	levels.Lock()
+	defer levels.Unlock()
+
+	delete(levels.m, subsystem)
+}
+
+// SetLevels replaces the levels of all subsystems with those in the given
+// specification, which is a comma separated list of subsystem=level, eg.
+// "retry=debug,fs=info". A blank spec clears all subsystem levels. If the spec
+// is invalid, no levels are changed. The value of the WR_LOG_LEVELS
+// environment variable is set this way when this package is initialised.
+func SetLevels(spec string) error {
+	parsed, err := parseLevels(spec)
+	if err != nil {
+		return err
+	}
+
+	levels.Lock()
+	defer levels.Unlock()
+
+	levels.m = parsed
+
+	return nil
+}
+
+// parseLevels parses a SetLevels() spec.
+func parseLevels(spec string) (map[string]log.Lvl, error) {
+	parsed := make(map[string]log.Lvl)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		subsystem, logLevel, err := parseLevelsEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[subsystem] = logLevel
+	}
+
+	return parsed, nil
+}
+
+// parseLevelsEntry parses a single "subsystem=lvl" entry of a SetLevels()
+// spec.
+func parseLevelsEntry(entry string) (string, log.Lvl, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", log.LvlDebug, fmt.Errorf("%w: %q", ErrBadLevels, entry)
+	}
+
+	logLevel, err := parseLevel(strings.TrimSpace(parts[1]))
+
+	return strings.TrimSpace(parts[0]), logLevel, err
+}
+
+// parseLevel is like lvlFromString(), but returns an error for invalid lvls.
+func parseLevel(lvl string) (log.Lvl, error) {
+	logLevel, err := log.LvlFromString(lvl)
+	if err != nil {
+		return logLevel, fmt.Errorf("%w: %q", ErrBadLevels, lvl)
+	}
+
+	return logLevel, nil
+}
+
+// Levels returns the current subsystem levels in the form accepted by
+// SetLevels(), sorted by subsystem.
+func Levels() string {
+	levels.RLock()
+	defer levels.RUnlock()
+
+	entries := make([]string, 0, len(levels.m))
+	for subsystem, lvl := range levels.m {
+		entries = append(entries, subsystem+"="+lvl.String())
+	}
+
+	sort.Strings(entries)
+
+	return strings.Join(entries, ",")
+}
+
+// SetLevelsFromFile calls SetLevels() with the content of the file at the
+// given path.
+func SetLevelsFromFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return SetLevels(strings.TrimSpace(string(content)))
+}
+
+// recordLevel returns the level set for the subsystem of the given record, if
+// any.
+func recordLevel(r *log.Record) (log.Lvl, bool) {
+	subsystem, ok := recordSubsystem(r)
+	if !ok {
+		return 0, false
+	}
+
+	levels.RLock()
+	defer levels.RUnlock()
+
+	lvl, ok := levels.m[subsystem]
+
+	return lvl, ok
+}
+
+// recordSubsystem returns the subsystem of the given record, if it was logged
+// by a Named() Logger. Since the subsystem is added after the caller's args, we
+// search from the end.
+func recordSubsystem(r *log.Record) (string, bool) {
+	for i := len(r.Ctx) - len(r.Ctx)%2 - 2; i >= 0; i -= 2 {
+		if r.Ctx[i] == subsystemKey {
+			subsystem, ok := r.Ctx[i+1].(string)
+
+			return subsystem, ok
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,131 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLevels(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("With logging to a buffer at warn level and a Named Logger", t, func() {
+		buff := ToBufferAtLevel("warn")
+		retryLogger := Named("retry")
+		fsLogger := Named("fs")
+
+		Convey("The Logger logs its subsystem name", func() {
+			retryLogger.Warn(ctx, "msg", "foo", 1)
+			So(buff.String(), ShouldContainSubstring, "msg=msg foo=1 subsystem=retry caller=")
+
+			retryLogger.Debug(ctx, "debug msg")
+			So(buff.String(), ShouldNotContainSubstring, "debug msg")
+		})
+
+		Convey("You can change the level of one subsystem", func() {
+			So(SetLevel("retry", "debug"), ShouldBeNil)
+			So(SetLevel("fs", "error"), ShouldBeNil)
+			So(Levels(), ShouldEqual, "fs=eror,retry=dbug")
+
+			retryLogger.Debug(ctx, "retry debug")
+			retryLogger.Info(ctx, "retry info")
+			fsLogger.Debug(ctx, "fs debug")
+			fsLogger.Warn(ctx, "fs warn")
+			fsLogger.Error(ctx, "fs error")
+			Debug(ctx, "global debug")
+			Warn(ctx, "global warn", "subsystem", "retry")
+
+			lmsg := buff.String()
+			So(lmsg, ShouldContainSubstring, "retry debug")
+			So(lmsg, ShouldContainSubstring, "retry info")
+			So(lmsg, ShouldNotContainSubstring, "fs debug")
+			So(lmsg, ShouldNotContainSubstring, "fs warn")
+			So(lmsg, ShouldContainSubstring, "fs error")
+			So(lmsg, ShouldNotContainSubstring, "global debug")
+			So(lmsg, ShouldContainSubstring, "global warn")
+
+			Convey("And clear it again", func() {
+				ClearLevel("retry")
+				So(Levels(), ShouldEqual, "fs=eror")
+				buff.Reset()
+
+				retryLogger.Debug(ctx, "retry debug")
+				So(buff.String(), ShouldBeBlank)
+			})
+
+			Convey("Subsystem args can't be spoofed", func() {
+				buff.Reset()
+				fsLogger.Debug(ctx, "spoofed", "subsystem", "retry")
+				So(buff.String(), ShouldBeBlank)
+			})
+		})
+
+		Convey("You can set all levels with a spec", func() {
+			So(SetLevels(" retry=debug, fs = info ,"), ShouldBeNil)
+			So(Levels(), ShouldEqual, "fs=info,retry=dbug")
+
+			fsLogger.Info(ctx, "fs info")
+			So(buff.String(), ShouldContainSubstring, "fs info")
+
+			So(SetLevels(""), ShouldBeNil)
+			So(Levels(), ShouldBeBlank)
+		})
+
+		Convey("Invalid specs are rejected without changing levels", func() {
+			So(SetLevels("retry=debug"), ShouldBeNil)
+
+			for _, spec := range []string{"retry", "=debug", "retry=foo", "fs=info,retry"} {
+				err := SetLevels(spec)
+				So(errors.Is(err, ErrBadLevels), ShouldBeTrue)
+			}
+
+			So(errors.Is(SetLevel("retry", "foo"), ErrBadLevels), ShouldBeTrue)
+			So(Levels(), ShouldEqual, "retry=dbug")
+		})
+
+		Convey("You can set levels from a file", func() {
+			path := filepath.Join(t.TempDir(), "levels")
+			err := ioutil.WriteFile(path, []byte("fs=crit\n"), 0600)
+			So(err, ShouldBeNil)
+
+			So(SetLevelsFromFile(path), ShouldBeNil)
+			So(Levels(), ShouldEqual, "fs=crit")
+
+			So(SetLevelsFromFile(path+".missing"), ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			ToDefault()
+			So(SetLevels(""), ShouldBeNil)
+		})
+	})
+}
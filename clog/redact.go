@@ -0,0 +1,189 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// RedactedMask replaces redacted values in logs.
+const RedactedMask = "[REDACTED]"
+
+// redaction holds what RedactFields() and RedactPatterns() registered.
+var redaction struct { //nolint:gochecknoglobals // redactions apply to all Sinks
+	sync.RWMutex
+	fields        map[string]bool
+	fieldPatterns []*regexp.Regexp
+	patterns      []*regexp.Regexp
+}
+
+// RedactFields registers field names, eg. "password" or "OS_PASSWORD", whose
+// values will be replaced with RedactedMask before any Sink sees them. Names
+// are matched case-insensitively against the keys of args to Debug() and
+// friends, and of fields added with ContextWith().
+//
+// Occurrences of name=value, name: value, -name value and --name value in
+// messages and string values (eg. in a logged command line) are also
+// redacted.
+func RedactFields(names ...string) {
+	redaction.Lock()
+	defer redaction.Unlock()
+
+	if redaction.fields == nil {
+		redaction.fields = make(map[string]bool)
+	}
+
+	for _, name := range names {
+		redaction.fields[strings.ToLower(name)] = true
+		redaction.fieldPatterns = append(redaction.fieldPatterns, fieldPattern(name))
+	}
+}
+
+// fieldPattern returns a regexp matching name=value, name: value or the
+// command line flag forms -name value and --name value, for the given field
+// name, capturing everything before the value in group 1. Values can be
+// quoted.
+func fieldPattern(name string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+
+	return regexp.MustCompile(`(?i)(--?` + quoted + `\s+|\b` + quoted + `\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s,;&]+)`)
+}
+
+// RedactPatterns registers regular expressions, eg. matching API keys, whose
+// matches in messages and string values will be replaced with RedactedMask
+// before any Sink sees them. Values that are []byte, errors or fmt.Stringers
+// are treated as strings, and slices, maps and structs as their %+v
+// formatting.
+func RedactPatterns(patterns ...*regexp.Regexp) {
+	redaction.Lock()
+	defer redaction.Unlock()
+
+	redaction.patterns = append(redaction.patterns, patterns...)
+}
+
+// ClearRedactions forgets everything registered with RedactFields() and
+// RedactPatterns().
+func ClearRedactions() {
+	redaction.Lock()
+	defer redaction.Unlock()
+
+	redaction.fields = nil
+	redaction.fieldPatterns = nil
+	redaction.patterns = nil
+}
+
+// redact returns the given record if nothing is registered for redaction, or
+// else a copy of it with registered fields and patterns redacted.
+func redact(r *log.Record) *log.Record {
+	redaction.RLock()
+	defer redaction.RUnlock()
+
+	if len(redaction.fields) == 0 && len(redaction.patterns) == 0 {
+		return r
+	}
+
+	redacted := *r
+	redacted.Msg = redactString(r.Msg)
+	redacted.Ctx = make([]interface{}, len(r.Ctx))
+
+	for i, v := range r.Ctx {
+		if i%2 == 1 {
+			redacted.Ctx[i] = redactValue(r.Ctx[i-1], v)
+		} else {
+			redacted.Ctx[i] = v
+		}
+	}
+
+	return &redacted
+}
+
+// redactValue returns RedactedMask if the given key is a registered field
+// name, or else the given value with any registered patterns redacted.
+func redactValue(key, v interface{}) interface{} {
+	if k, ok := key.(string); ok && redaction.fields[strings.ToLower(k)] {
+		return RedactedMask
+	}
+
+	s, ok := valueString(v)
+	if !ok {
+		return v
+	}
+
+	if redactedS := redactString(s); redactedS != s {
+		return redactedS
+	}
+
+	return v
+}
+
+// valueString returns the text of the given value if it is a string, []byte,
+// error or fmt.Stringer, or else its %+v formatting if it is a slice, array,
+// map, struct or pointer (eg. a list of environment variables), and whether it
+// was any of those.
+func valueString(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case []byte:
+		return string(val), true
+	case error:
+		return val.Error(), true
+	case fmt.Stringer:
+		return val.String(), true
+	default:
+		return compositeString(v)
+	}
+}
+
+// compositeString returns the %+v formatting of the given value if it is not
+// nil or a bool, number or string, ie. if it is a slice, array, map, struct,
+// pointer or similar, and whether it was.
+func compositeString(v interface{}) (string, bool) {
+	if kind := reflect.ValueOf(v).Kind(); kind < reflect.Array || kind == reflect.String {
+		return "", false
+	}
+
+	return fmt.Sprintf("%+v", v), true
+}
+
+// redactString returns s with registered fields and patterns redacted.
+func redactString(s string) string {
+	for _, re := range redaction.fieldPatterns {
+		s = re.ReplaceAllString(s, "${1}"+RedactedMask)
+	}
+
+	for _, re := range redaction.patterns {
+		s = re.ReplaceAllString(s, RedactedMask)
+	}
+
+	return s
+}
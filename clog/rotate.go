@@ -0,0 +1,435 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// logFilePerms are the permissions of log files we create.
+const logFilePerms = 0666
+
+// rotatedTimeFormat is the format of the timestamp suffix given to rotated log
+// files, which sorts in time order.
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// gzipSuffix is the suffix of compressed rotated log files.
+const gzipSuffix = ".gz"
+
+// Rotation configures how a log file is rotated. When a file is rotated, it is
+// renamed by suffixing it with a timestamp, and a new file is started at the
+// original path. The zero value never rotates.
+type Rotation struct {
+	// MaxSize rotates the file before a write would make it larger than this
+	// many bytes. 0 means no limit.
+	MaxSize int64
+
+	// MaxAge rotates the file once we have been writing to it for this long.
+	// 0 means no limit.
+	MaxAge time.Duration
+
+	// Compress gzips rotated files.
+	Compress bool
+
+	// MaxFiles is the maximum number of rotated files to keep, with the oldest
+	// being deleted. 0 means keep them all.
+	MaxFiles int
+}
+
+// rotatingFile is an io.WriteCloser that appends to a file at a path, rotating
+// it according to a Rotation. It is concurrent safe.
+type rotatingFile struct {
+	path     string
+	rotation Rotation
+	now      func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	tidyMu  sync.Mutex
+	tidying sync.WaitGroup
+}
+
+// openRotatingFile opens the file at the given path for appending, creating it
+// if necessary, and returns a rotatingFile for it that can be reopened by
+// ReopenFiles().
+func openRotatingFile(path string, rotation Rotation) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, rotation: rotation, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	openFiles.add(r)
+
+	return r, nil
+}
+
+// open opens our path for appending, noting its current size.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, logFilePerms)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return err
+	}
+
+	r.f, r.size, r.opened = f, info.Size(), r.now()
+
+	return nil
+}
+
+// closeFile closes our file, if open.
+func (r *rotatingFile) closeFile() error {
+	if r.f == nil {
+		return nil
+	}
+
+	f := r.f
+	r.f = nil
+
+	return f.Close()
+}
+
+// Write implements io.Writer, rotating our file first if necessary. If our
+// file couldn't be reopened after an earlier rotation, we try again.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shouldRotate(len(p)) {
+		r.rotate()
+	}
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// shouldRotate returns true if our file is old enough to rotate, or if writing
+// the given number of bytes would make it too large. Empty files are only
+// rotated for age.
+func (r *rotatingFile) shouldRotate(n int) bool {
+	if r.rotation.MaxAge > 0 && r.now().Sub(r.opened) >= r.rotation.MaxAge {
+		return true
+	}
+
+	return r.rotation.MaxSize > 0 && r.size > 0 && r.size+int64(n) > r.rotation.MaxSize
+}
+
+// rotate closes our file, renames it with a timestamp suffix and opens a new
+// file at our path, then compresses and deletes old rotated files as
+// configured in the background, so as not to hold up logging.
+//
+// If renaming fails, we carry on appending to the file, and try again after
+// another MaxSize bytes or MaxAge. If reopening fails, Write() will try again.
+// Failures are logged at warn level in the background, since we are probably
+// being called while logging.
+func (r *rotatingFile) rotate() {
+	if err := r.closeFile(); err != nil {
+		go warnRotationFailed("close", r.path, err)
+	}
+
+	rotated := r.path + "." + r.now().UTC().Format(rotatedTimeFormat)
+	renameErr := os.Rename(r.path, rotated)
+
+	if err := r.open(); err != nil {
+		return
+	}
+
+	if renameErr != nil {
+		go warnRotationFailed("rename", r.path, renameErr)
+
+		r.size = 0
+
+		return
+	}
+
+	r.tidying.Add(1)
+
+	go r.tidy()
+}
+
+// warnRotationFailed logs at warn level that the given rotation action failed
+// for the given path.
+func warnRotationFailed(action, path string, err error) {
+	Warn(context.Background(), "log file rotation failed", "action", action, "path", path, "err", err)
+}
+
+// tidy compresses and deletes old rotated files as configured, logging any
+// failures. Only one tidy runs at a time.
+func (r *rotatingFile) tidy() {
+	defer r.tidying.Done()
+
+	r.tidyMu.Lock()
+	defer r.tidyMu.Unlock()
+
+	if r.rotation.Compress {
+		r.compressRotatedFiles()
+	}
+
+	if err := r.removeOldFiles(); err != nil {
+		go warnRotationFailed("remove old files", r.path, err)
+	}
+}
+
+// compressRotatedFiles gzips any of our rotated files that aren't already
+// compressed, logging any failures.
+func (r *rotatingFile) compressRotatedFiles() {
+	rotated, err := r.rotatedFiles()
+	if err != nil {
+		go warnRotationFailed("compress", r.path, err)
+
+		return
+	}
+
+	for _, path := range rotated {
+		if strings.HasSuffix(path, gzipSuffix) {
+			continue
+		}
+
+		if err = compressFile(path); err != nil {
+			go warnRotationFailed("compress", path, err)
+		}
+	}
+}
+
+// compressFile gzips the file at the given path to a new file with a .gz
+// suffix, then deletes the original.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+gzipSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, logFilePerms)
+	if err != nil {
+		return err
+	}
+
+	if err = gzipCopy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// gzipCopy writes the gzipped content of in to out.
+func gzipCopy(out io.Writer, in io.Reader) error {
+	gz := gzip.NewWriter(out)
+
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+
+		return err
+	}
+
+	return gz.Close()
+}
+
+// removeOldFiles deletes our oldest rotated files, so that no more than
+// MaxFiles remain. Files that have already been deleted by something else are
+// ignored.
+func (r *rotatingFile) removeOldFiles() error {
+	if r.rotation.MaxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := r.rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	for len(rotated) > r.rotation.MaxFiles {
+		if err = removeIfExists(rotated[0]); err != nil {
+			return err
+		}
+
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+// removeIfExists deletes the file at the given path, treating it not existing
+// as success.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// rotatedFiles returns the paths of our rotated files, oldest first.
+func (r *rotatingFile) rotatedFiles() ([]string, error) {
+	dir, base := filepath.Split(r.path)
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+
+	for _, entry := range entries {
+		if isRotatedName(entry.Name(), base) {
+			rotated = append(rotated, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	sort.Strings(rotated)
+
+	return rotated, nil
+}
+
+// isRotatedName returns true if the given file name is that of a rotated
+// version of a file with the given base name.
+func isRotatedName(name, base string) bool {
+	if !strings.HasPrefix(name, base+".") {
+		return false
+	}
+
+	suffix := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), gzipSuffix)
+	_, err := time.Parse(rotatedTimeFormat, suffix)
+
+	return err == nil
+}
+
+// Reopen closes and reopens our file at our path, for use after some external
+// tool like logrotate has moved it.
+func (r *rotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	closeErr := r.closeFile()
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// Close implements io.Closer, closing our file and waiting for any background
+// compression and deletion of rotated files to finish. We will no longer be
+// reopened by ReopenFiles().
+func (r *rotatingFile) Close() error {
+	openFiles.remove(r)
+
+	r.mu.Lock()
+	err := r.closeFile()
+	r.mu.Unlock()
+
+	r.tidying.Wait()
+
+	return err
+}
+
+// openFiles holds all the rotatingFiles that haven't been closed.
+var openFiles = &rotatingFiles{files: make(map[*rotatingFile]bool)} //nolint:gochecknoglobals // for Reopen()
+
+// rotatingFiles is a concurrent safe set of rotatingFile.
+type rotatingFiles struct {
+	mu    sync.Mutex
+	files map[*rotatingFile]bool
+}
+
+// add adds a rotatingFile to the set.
+func (rf *rotatingFiles) add(r *rotatingFile) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	rf.files[r] = true
+}
+
+// remove removes a rotatingFile from the set.
+func (rf *rotatingFiles) remove(r *rotatingFile) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	delete(rf.files, r)
+}
+
+// ReopenFiles closes and reopens all the files that the global logger is
+// logging to. Use this after an external tool like logrotate has moved them
+// (see also ReopenFilesOnSignal()). Returns the first error encountered, but
+// still tries to reopen the other files.
+func ReopenFiles() error {
+	openFiles.mu.Lock()
+	defer openFiles.mu.Unlock()
+
+	var firstErr error
+
+	for r := range openFiles.files {
+		if err := r.Reopen(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// ReopenFilesOnSIGHUP makes the global logger ReopenFiles() whenever this
+// process receives SIGHUP, which is what logrotate and similar tools send after
+// moving log files. Failures to reopen are logged at error level. Call the
+// returned function to stop.
+func ReopenFilesOnSIGHUP() (stop func()) {
+	return onSignal(syscall.SIGHUP, func() {
+		if err := ReopenFiles(); err != nil {
+			Error(context.Background(), "reopening log files failed", "err", err)
+		}
+	})
+}
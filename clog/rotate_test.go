@@ -0,0 +1,240 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/internal"
+)
+
+func TestRotation(t *testing.T) {
+	Convey("Given a rotatingFile with a fake clock", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "wr.log")
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		open := func(rotation Rotation) *rotatingFile {
+			r := &rotatingFile{path: path, rotation: rotation, now: func() time.Time { return now }}
+			So(r.open(), ShouldBeNil)
+
+			return r
+		}
+
+		write := func(r *rotatingFile, s string) {
+			n, err := r.Write([]byte(s))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(s))
+			now = now.Add(1 * time.Second)
+		}
+
+		rotated := func(r *rotatingFile) []string {
+			paths, err := r.rotatedFiles()
+			So(err, ShouldBeNil)
+
+			return paths
+		}
+
+		Convey("It doesn't rotate with the zero Rotation", func() {
+			r := open(Rotation{})
+			defer r.Close()
+
+			for i := 0; i < 10; i++ {
+				write(r, "0123456789")
+			}
+
+			So(rotated(r), ShouldBeEmpty)
+			So(len(internal.FileAsString(path)), ShouldEqual, 100)
+		})
+
+		Convey("It rotates by size", func() {
+			r := open(Rotation{MaxSize: 25})
+			defer r.Close()
+
+			write(r, "0123456789")
+			write(r, "0123456789")
+			So(rotated(r), ShouldBeEmpty)
+
+			write(r, "abcdefghij")
+			paths := rotated(r)
+			So(len(paths), ShouldEqual, 1)
+			So(filepath.Base(paths[0]), ShouldEqual, "wr.log.20200102-030407.000000000")
+			So(internal.FileAsString(paths[0]), ShouldEqual, "01234567890123456789")
+			So(internal.FileAsString(path), ShouldEqual, "abcdefghij")
+
+			Convey("Even when appending to an existing file", func() {
+				So(r.Close(), ShouldBeNil)
+				r = open(Rotation{MaxSize: 25})
+
+				write(r, "0123456789")
+				write(r, "0123456789")
+				So(len(rotated(r)), ShouldEqual, 2)
+				So(internal.FileAsString(path), ShouldEqual, "0123456789")
+			})
+
+			Convey("Writes larger than MaxSize go to a file on their own", func() {
+				write(r, "0123456789012345678901234567890123456789")
+				So(len(rotated(r)), ShouldEqual, 2)
+				So(len(internal.FileAsString(path)), ShouldEqual, 40)
+			})
+		})
+
+		Convey("It rotates by age", func() {
+			r := open(Rotation{MaxAge: 2 * time.Second})
+			defer r.Close()
+
+			write(r, "a")
+			write(r, "b")
+			So(rotated(r), ShouldBeEmpty)
+
+			write(r, "c")
+			r.tidying.Wait()
+			So(len(rotated(r)), ShouldEqual, 1)
+			So(internal.FileAsString(path), ShouldEqual, "c")
+		})
+
+		Convey("It can compress and limit the number of rotated files", func() {
+			r := open(Rotation{MaxSize: 1, Compress: true, MaxFiles: 2})
+			defer r.Close()
+
+			for _, s := range []string{"a", "b", "c", "d"} {
+				write(r, s)
+			}
+
+			r.tidying.Wait()
+			paths := rotated(r)
+			So(len(paths), ShouldEqual, 2)
+			So(gunzip(paths[0]), ShouldEqual, "b")
+			So(gunzip(paths[1]), ShouldEqual, "c")
+			So(internal.FileAsString(path), ShouldEqual, "d")
+		})
+
+		Convey("Failures to rotate don't stop logging, and are logged", func() {
+			warnPath := filepath.Join(t.TempDir(), "warn.log")
+			So(ToFileAtLevel(warnPath, "warn"), ShouldBeNil)
+			defer ToDefault()
+
+			r := open(Rotation{MaxSize: 1, Compress: true})
+			defer r.Close()
+
+			blocked := path + "." + now.Add(1*time.Second).UTC().Format(rotatedTimeFormat)
+			So(os.MkdirAll(filepath.Join(blocked, "subdir"), 0700), ShouldBeNil)
+
+			write(r, "a")
+			write(r, "b")
+			So(internal.FileAsString(path), ShouldEqual, "ab")
+
+			uncompressible := path + "." + now.UTC().Format(rotatedTimeFormat) + gzipSuffix
+			So(os.MkdirAll(filepath.Join(uncompressible, "subdir"), 0700), ShouldBeNil)
+
+			write(r, "c")
+			So(internal.FileAsString(path), ShouldEqual, "c")
+			r.tidying.Wait()
+
+			logged := ""
+			for i := 0; i < 1000 && strings.Count(logged, "rotation failed") < 2; i++ {
+				<-time.After(1 * time.Millisecond)
+				logged = internal.FileAsString(warnPath)
+			}
+
+			So(logged, ShouldContainSubstring, `msg="log file rotation failed" action=rename`)
+			So(logged, ShouldContainSubstring, `msg="log file rotation failed" action=compress`)
+		})
+
+		Convey("Unrelated files are not considered rotated files", func() {
+			r := open(Rotation{MaxSize: 1, MaxFiles: 1})
+			defer r.Close()
+
+			for _, name := range []string{"wr.log.old", "wr.logfoo", "other.log.20200102-030407.000000000"} {
+				err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0600)
+				So(err, ShouldBeNil)
+			}
+
+			write(r, "a")
+			write(r, "b")
+			write(r, "c")
+			r.tidying.Wait()
+			So(len(rotated(r)), ShouldEqual, 1)
+
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 5)
+		})
+	})
+
+	Convey("You can log to a rotating file, and reopen it after it is moved", t, func() {
+		ctx := context.Background()
+		path := internal.FilePathInTempDir(t, "wr.log")
+		moved := path + ".moved"
+
+		err := ToRotatingFileAtLevel(path, "debug", Rotation{MaxSize: 1 << 20})
+		So(err, ShouldBeNil)
+		defer ToDefault()
+
+		Info(ctx, "before")
+		So(os.Rename(path, moved), ShouldBeNil)
+		Info(ctx, "during")
+		So(ReopenFiles(), ShouldBeNil)
+		Info(ctx, "after")
+
+		So(internal.FileAsString(moved), ShouldContainSubstring, "msg=before")
+		So(internal.FileAsString(moved), ShouldContainSubstring, "msg=during")
+		So(internal.FileAsString(path), ShouldNotContainSubstring, "msg=during")
+		So(internal.FileAsString(path), ShouldContainSubstring, "msg=after")
+
+		Convey("Closed files are not reopened", func() {
+			ToDefault()
+			So(os.Remove(path), ShouldBeNil)
+			So(ReopenFiles(), ShouldBeNil)
+			_, err = os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+// gunzip returns the decompressed content of the gzipped file at the given
+// path.
+func gunzip(path string) string {
+	f, err := os.Open(path)
+	So(err, ShouldBeNil)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	So(err, ShouldBeNil)
+
+	content, err := ioutil.ReadAll(gz)
+	So(err, ShouldBeNil)
+
+	return string(content)
+}
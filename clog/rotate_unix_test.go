@@ -0,0 +1,68 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/internal"
+)
+
+func TestReopenOnSIGHUP(t *testing.T) {
+	Convey("Log files are reopened on SIGHUP", t, func() {
+		ctx := context.Background()
+		path := internal.FilePathInTempDir(t, "wr.log")
+
+		err := ToFileAtLevel(path, "debug")
+		So(err, ShouldBeNil)
+		defer ToDefault()
+
+		stop := ReopenFilesOnSIGHUP()
+		defer stop()
+
+		So(os.Rename(path, path+".moved"), ShouldBeNil)
+		So(syscall.Kill(os.Getpid(), syscall.SIGHUP), ShouldBeNil)
+
+		reopened := false
+		for i := 0; i < 1000 && !reopened; i++ {
+			_, err = os.Stat(path)
+			reopened = err == nil
+			time.Sleep(1 * time.Millisecond)
+		}
+
+		So(reopened, ShouldBeTrue)
+		Info(ctx, "after")
+		So(internal.FileAsString(path), ShouldContainSubstring, "msg=after")
+	})
+}
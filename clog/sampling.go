@@ -0,0 +1,349 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Sampling configures the suppression of repeated log messages of a level. Set
+// it with SetSampling(). The zero value suppresses nothing.
+type Sampling struct {
+	// Interval is the period over which messages are counted for sampling, and
+	// within which identical messages are deduplicated. 0 means forever,
+	// except that sampling counts are reset whenever 10000 different message
+	// texts have been counted, to limit memory use.
+	Interval time.Duration
+
+	// First is the number of messages with the same text that are logged per
+	// Interval before sampling starts. 0 disables sampling.
+	First int
+
+	// Thereafter is how often messages are logged once sampling has started;
+	// every Thereafter-th message with the same text is logged. 0 means none
+	// are logged.
+	Thereafter int
+
+	// Dedup suppresses messages that are identical (same text and fields) to
+	// the previous logged message of the level. A summary of the suppressed
+	// message is logged, with " (repeated N times)" appended to its text,
+	// when Interval has passed since it was first logged, before a different
+	// message, or when Flush() is called. Deduplicated messages don't count
+	// towards sampling.
+	Dedup bool
+}
+
+// maxSampledTexts is the number of different message texts a sampler counts
+// before it resets its counts.
+const maxSampledTexts = 10000
+
+// samplers holds the samplers for each level that has Sampling set.
+var samplers struct { //nolint:gochecknoglobals // sampling applies to the global logger
+	sync.RWMutex
+	m map[log.Lvl]*sampler
+}
+
+// SetSampling sets how repeated messages at the given level are suppressed.
+// Valid lvls are as for ToBufferAtLevel(). Supply the zero Sampling to stop
+// suppressing messages at that level. Any deduplicated message that has not
+// yet been summarised is forgotten.
+func SetSampling(lvl string, sampling Sampling) error {
+	logLevel, err := parseLevel(lvl)
+	if err != nil {
+		return err
+	}
+
+	samplers.Lock()
+	defer samplers.Unlock()
+
+	if samplers.m == nil {
+		samplers.m = make(map[log.Lvl]*sampler)
+	}
+
+	if old, exists := samplers.m[logLevel]; exists {
+		old.stop()
+	}
+
+	if sampling == (Sampling{}) {
+		delete(samplers.m, logLevel)
+
+		return nil
+	}
+
+	samplers.m[logLevel] = newSampler(sampling, time.Now, time.AfterFunc)
+
+	return nil
+}
+
+// sample returns the records that should be logged given that the given
+// record was logged: none if it should be suppressed, or the record, possibly
+// preceded by a deduplication summary.
+func sample(r *log.Record) []*log.Record {
+	samplers.RLock()
+	s, exists := samplers.m[r.Lvl]
+	samplers.RUnlock()
+
+	if !exists {
+		return []*log.Record{r}
+	}
+
+	return s.sample(r)
+}
+
+// flushSummaries logs the summaries of the messages currently being
+// deduplicated by all our samplers, returning the first error.
+func flushSummaries() error {
+	samplers.RLock()
+	list := make([]*sampler, 0, len(samplers.m))
+
+	for _, s := range samplers.m {
+		list = append(list, s)
+	}
+	samplers.RUnlock()
+
+	var firstErr error
+
+	for _, s := range list {
+		if err := s.flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// sampler applies a Sampling to the records of one level.
+type sampler struct {
+	Sampling
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) *time.Timer
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	last        *log.Record
+	lastKey     string
+	lastTime    time.Time
+	repeated    int
+	timer       *time.Timer
+}
+
+// newSampler returns a sampler that applies the given Sampling, using the
+// given function to tell the time. If afterFunc is not nil, it is used to
+// schedule the logging of deduplication summaries once Interval has passed.
+func newSampler(sampling Sampling, now func() time.Time,
+	afterFunc func(time.Duration, func()) *time.Timer) *sampler {
+	return &sampler{
+		Sampling:    sampling,
+		now:         now,
+		afterFunc:   afterFunc,
+		windowStart: now(),
+		counts:      make(map[string]int),
+	}
+}
+
+// sample returns the records that should be logged given that the given
+// record was logged.
+func (s *sampler) sample(r *log.Record) []*log.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key := recordKey(r)
+
+	if s.isRepeat(key, now) {
+		s.countRepeat(now)
+
+		return nil
+	}
+
+	records := make([]*log.Record, 0, 2)
+	if summary := s.pendingSummary(now); summary != nil {
+		records = append(records, summary)
+	}
+
+	s.last, s.lastKey = nil, ""
+
+	if !s.allowed(r, now) {
+		return records
+	}
+
+	if s.Dedup {
+		last := *r
+		s.last, s.lastKey, s.lastTime = &last, key, now
+	}
+
+	return append(records, r)
+}
+
+// isRepeat returns true if we are deduplicating, and the record with the given
+// key is the same as the last one we logged, within our Interval.
+func (s *sampler) isRepeat(key string, now time.Time) bool {
+	return s.Dedup && s.last != nil && key == s.lastKey && !s.expired(s.lastTime, now)
+}
+
+// countRepeat counts a suppressed repeat of our last record. On the first
+// repeat, schedules the logging of a summary for when our Interval has passed.
+// You must hold our lock.
+func (s *sampler) countRepeat(now time.Time) {
+	s.repeated++
+
+	if s.repeated > 1 || s.afterFunc == nil || s.Interval <= 0 {
+		return
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	s.timer = s.afterFunc(s.Interval-now.Sub(s.lastTime), s.summariseExpired)
+}
+
+// summariseExpired logs the summary of our suppressed repeats if our Interval
+// has passed since our last record was logged. Since this happens in the
+// background, failures are written to STDERR.
+func (s *sampler) summariseExpired() {
+	s.mu.Lock()
+
+	var summary *log.Record
+	if now := s.now(); s.expired(s.lastTime, now) {
+		summary = s.pendingSummary(now)
+	}
+
+	s.mu.Unlock()
+
+	if err := writeSummary(summary); err != nil {
+		fmt.Fprintf(os.Stderr, "clog: failed to log summary of repeated messages: %s\n", err)
+	}
+}
+
+// flush logs the summary of our suppressed repeats, if any.
+func (s *sampler) flush() error {
+	s.mu.Lock()
+	summary := s.pendingSummary(s.now())
+	s.mu.Unlock()
+
+	return writeSummary(summary)
+}
+
+// writeSummary sends the given summary record to all current Sinks, unless it
+// is nil.
+func writeSummary(summary *log.Record) error {
+	if summary == nil {
+		return nil
+	}
+
+	return writeToSinks(summary)
+}
+
+// pendingSummary returns a summary record timed now of the suppressed repeats
+// of our last record, and forgets them. Returns nil if there are none. You
+// must hold our lock.
+func (s *sampler) pendingSummary(now time.Time) *log.Record {
+	if s.repeated == 0 {
+		return nil
+	}
+
+	summary := summaryRecord(s.last, s.repeated, now)
+	s.repeated = 0
+
+	return summary
+}
+
+// stop stops any scheduled logging of a summary.
+func (s *sampler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// allowed returns true if the given record should be logged according to our
+// sampling counts, which it increments.
+func (s *sampler) allowed(r *log.Record, now time.Time) bool {
+	if s.First <= 0 {
+		return true
+	}
+
+	n := s.count(r.Msg, now)
+	if n <= s.First {
+		return true
+	}
+
+	return s.Thereafter > 0 && (n-s.First)%s.Thereafter == 0
+}
+
+// count increments and returns the count of records with the given message
+// text in the current window. A new window is started if our Interval has
+// passed, or if the text is new and we are already counting maxSampledTexts
+// texts.
+func (s *sampler) count(msg string, now time.Time) int {
+	if _, counted := s.counts[msg]; s.expired(s.windowStart, now) || (!counted && len(s.counts) >= maxSampledTexts) {
+		s.windowStart = now
+		s.counts = make(map[string]int)
+	}
+
+	s.counts[msg]++
+
+	return s.counts[msg]
+}
+
+// expired returns true if our Interval has passed between start and now.
+func (s *sampler) expired(start, now time.Time) bool {
+	return s.Interval > 0 && now.Sub(start) >= s.Interval
+}
+
+// recordKey returns a string that is the same for records with the same
+// message and fields.
+func recordKey(r *log.Record) string {
+	var b strings.Builder
+
+	b.WriteString(r.Msg)
+
+	for _, v := range r.Ctx {
+		fmt.Fprintf(&b, "\xff%v", v)
+	}
+
+	return b.String()
+}
+
+// summaryRecord returns a copy of the given record, timed now, with a message
+// saying it was repeated the given number of times.
+func summaryRecord(r *log.Record, repeated int, now time.Time) *log.Record {
+	summary := *r
+	summary.Time = now
+	summary.Msg = fmt.Sprintf("%s (repeated %d times)", r.Msg, repeated)
+
+	return &summary
+}
@@ -0,0 +1,215 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/internal"
+)
+
+func TestSampling(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Given a sampler with a fake clock", t, func() {
+		now := time.Now()
+		clock := func() time.Time { return now }
+
+		record := func(msg string, ctx ...interface{}) *log.Record {
+			return &log.Record{Lvl: log.LvlDebug, Msg: msg, Ctx: ctx}
+		}
+
+		msgs := func(s *sampler, r *log.Record) []string {
+			var logged []string
+			for _, sampled := range s.sample(r) {
+				logged = append(logged, sampled.Msg)
+			}
+
+			return logged
+		}
+
+		Convey("Sampling logs the first N per interval, then every Mth", func() {
+			s := newSampler(Sampling{Interval: time.Second, First: 2, Thereafter: 3}, clock, nil)
+
+			var logged []string
+			for i := 0; i < 9; i++ {
+				logged = append(logged, msgs(s, record("a", "i", i))...)
+				logged = append(logged, msgs(s, record("b"))...)
+			}
+
+			So(strings.Join(logged, ""), ShouldEqual, "abababab")
+
+			now = now.Add(time.Second)
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+			So(msgs(s, record("a")), ShouldBeNil)
+		})
+
+		Convey("With Thereafter 0, sampling drops everything after First", func() {
+			s := newSampler(Sampling{First: 1}, clock, nil)
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+
+			for i := 0; i < 5; i++ {
+				So(msgs(s, record("a")), ShouldBeNil)
+			}
+		})
+
+		Convey("Dedup suppresses identical records and summarises them", func() {
+			s := newSampler(Sampling{Interval: time.Minute, Dedup: true}, clock, nil)
+
+			So(msgs(s, record("a", "k", 1)), ShouldResemble, []string{"a"})
+			for i := 0; i < 532; i++ {
+				So(msgs(s, record("a", "k", 1)), ShouldBeNil)
+			}
+
+			So(msgs(s, record("a", "k", 2)), ShouldResemble, []string{"a (repeated 532 times)", "a"})
+			So(msgs(s, record("b")), ShouldResemble, []string{"b"})
+
+			Convey("Until the interval passes", func() {
+				So(msgs(s, record("b")), ShouldBeNil)
+				now = now.Add(time.Minute)
+				So(msgs(s, record("b")), ShouldResemble, []string{"b (repeated 1 times)", "b"})
+			})
+
+			Convey("Summaries keep the fields of the repeated record", func() {
+				So(msgs(s, record("b")), ShouldBeNil)
+				sampled := s.sample(record("c"))
+				So(len(sampled), ShouldEqual, 2)
+				So(sampled[0].Ctx, ShouldBeNil)
+
+				So(msgs(s, record("a", "k", 2)), ShouldResemble, []string{"a"})
+				So(msgs(s, record("a", "k", 2)), ShouldBeNil)
+				sampled = s.sample(record("c"))
+				So(sampled[0].Ctx, ShouldResemble, []interface{}{"k", 2})
+			})
+		})
+
+		Convey("Sampling counts are reset when there are too many different texts", func() {
+			s := newSampler(Sampling{First: 1}, clock, nil)
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+			So(msgs(s, record("a")), ShouldBeNil)
+
+			for i := 1; i < maxSampledTexts; i++ {
+				So(len(s.sample(record(fmt.Sprintf("msg%d", i)))), ShouldEqual, 1)
+			}
+
+			So(len(s.counts), ShouldEqual, maxSampledTexts)
+			So(msgs(s, record("b")), ShouldResemble, []string{"b"})
+			So(len(s.counts), ShouldEqual, 1)
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+		})
+
+		Convey("Dedup and sampling work together", func() {
+			s := newSampler(Sampling{First: 1, Dedup: true}, clock, nil)
+
+			So(msgs(s, record("a")), ShouldResemble, []string{"a"})
+			So(msgs(s, record("a")), ShouldBeNil)
+			So(msgs(s, record("a", "k", 1)), ShouldResemble, []string{"a (repeated 1 times)"})
+			So(msgs(s, record("a", "k", 1)), ShouldBeNil)
+			So(msgs(s, record("b")), ShouldResemble, []string{"b"})
+		})
+	})
+
+	Convey("Pending summaries are logged when the Interval ends", t, func() {
+		path := internal.FilePathInTempDir(t, "sampled.log")
+		So(ToFileAtLevel(path, "debug"), ShouldBeNil)
+		So(SetSampling("debug", Sampling{Interval: 10 * time.Millisecond, Dedup: true}), ShouldBeNil)
+
+		defer func() {
+			So(SetSampling("debug", Sampling{}), ShouldBeNil)
+			ToDefault()
+		}()
+
+		for i := 0; i < 3; i++ {
+			Debug(ctx, "looping")
+		}
+
+		logged := ""
+		for i := 0; i < 1000 && !strings.Contains(logged, "repeated"); i++ {
+			<-time.After(1 * time.Millisecond)
+			logged = internal.FileAsString(path)
+		}
+
+		So(logged, ShouldContainSubstring, `msg="looping (repeated 2 times)"`)
+	})
+
+	Convey("With logging to a buffer and Sampling set for a level", t, func() {
+		buff := ToBufferAtLevel("debug")
+		So(SetSampling("debug", Sampling{Dedup: true}), ShouldBeNil)
+
+		for i := 0; i < 10; i++ {
+			Debug(ctx, "backoff", "sleep", time.Millisecond)
+			Info(ctx, "info")
+		}
+
+		Debug(ctx, "retried")
+
+		lmsg := buff.String()
+		So(strings.Count(lmsg, "msg=backoff "), ShouldEqual, 1)
+		So(lmsg, ShouldContainSubstring, `msg="backoff (repeated 9 times)" sleep=1ms`)
+		So(strings.Count(lmsg, "msg=info"), ShouldEqual, 10)
+		So(lmsg, ShouldContainSubstring, "msg=retried")
+
+		Convey("Pending summaries are logged by Flush()", func() {
+			buff.Reset()
+			Debug(ctx, "retried")
+			Debug(ctx, "retried")
+			So(buff.String(), ShouldNotContainSubstring, "repeated")
+
+			So(Flush(ctx), ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, `msg="retried (repeated 2 times)"`)
+
+			So(Flush(ctx), ShouldBeNil)
+			So(strings.Count(buff.String(), "repeated"), ShouldEqual, 1)
+		})
+
+		Convey("Which you can turn off again", func() {
+			So(SetSampling("debug", Sampling{}), ShouldBeNil)
+			buff.Reset()
+
+			Debug(ctx, "retried")
+			Debug(ctx, "retried")
+			So(strings.Count(buff.String(), "msg=retried"), ShouldEqual, 2)
+		})
+
+		Convey("Invalid levels are rejected", func() {
+			err := SetSampling("foo", Sampling{Dedup: true})
+			So(errors.Is(err, ErrBadLevels), ShouldBeTrue)
+		})
+
+		Reset(func() {
+			ToDefault()
+			So(SetSampling("debug", Sampling{}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,223 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"io"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Sink is a destination for log records, with its own level filter and
+// format. The global logger sends every record to all of the current Sinks,
+// which you can change at any time with AddSink() and RemoveSink().
+type Sink struct {
+	handler log.Handler
+	lvl     log.Lvl
+	closer  io.Closer
+	async   *asyncHandler
+}
+
+// newSink returns a Sink that passes records at or above the given level to
+// the given handler. If closer is not nil, it will be closed when the Sink is
+// removed.
+func newSink(h log.Handler, lvl log.Lvl, closer io.Closer) *Sink {
+	return &Sink{handler: h, lvl: lvl, closer: closer}
+}
+
+// log passes the given record to our handler if it is at or above our level,
+// or the given subsystem level if set is true.
+func (s *Sink) log(r *log.Record, subsystemLvl log.Lvl, set bool) error {
+	lvl := s.lvl
+	if set {
+		lvl = subsystemLvl
+	}
+
+	if r.Lvl > lvl {
+		return nil
+	}
+
+	return s.handler.Log(r)
+}
+
+// close waits for any queued records to be written if we are asynchronous,
+// then closes our closer, if any.
+func (s *Sink) close() error {
+	if s.async != nil {
+		s.async.close()
+	}
+
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// sinks holds the current Sinks of the global logger.
+var sinks struct { //nolint:gochecknoglobals // like the log15 root logger we wrap
+	sync.RWMutex
+	list []*Sink
+}
+
+// logToSinks is the log.Handler of the global logger, sending the record to
+// all current Sinks with writeToSinks(). Sensitive values are first redacted
+// according to RedactFields() and RedactPatterns(), and repeated records may be
+// suppressed according to SetSampling(). Returns the first error from a Sink,
+// but still logs to the others.
+func logToSinks(r *log.Record) error {
+	var firstErr error
+
+	for _, sampled := range sample(redact(r)) {
+		if err := writeToSinks(sampled); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// writeToSinks sends the record to all current Sinks, filtered on their
+// levels, or on the level of the record's subsystem if one has been set with
+// SetLevel(). Returns the first error from a Sink, but still logs to the
+// others.
+func writeToSinks(r *log.Record) error {
+	subsystemLvl, set := recordLevel(r)
+
+	sinks.RLock()
+	defer sinks.RUnlock()
+
+	var firstErr error
+
+	for _, s := range sinks.list {
+		if err := s.log(r, subsystemLvl, set); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// AddSink adds a Sink to the global logger that logs to the given writer at the
+// given level, in the given format, alongside any existing Sinks. Returns the
+// Sink so that you can RemoveSink() it later.
+func AddSink(w io.Writer, lvl string, format Format) *Sink {
+	return addSink(newSink(log.StreamHandler(w, format.log15Format()), lvlFromString(lvl), nil))
+}
+
+// AddFileSink is like AddSink(), but logs to a file at the given path, which is
+// appended to if it already exists. The file is closed when the Sink is
+// removed.
+func AddFileSink(path, lvl string, format Format) (*Sink, error) {
+	return AddRotatingFileSink(path, lvl, format, Rotation{})
+}
+
+// AddRotatingFileSink is like AddFileSink(), but the file is rotated according
+// to the given Rotation.
+func AddRotatingFileSink(path, lvl string, format Format, rotation Rotation) (*Sink, error) {
+	h, closer, err := fileHandler(path, format, rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	return addSink(newSink(h, lvlFromString(lvl), closer)), nil
+}
+
+// addSink appends the given Sink to our sinks and returns it.
+func addSink(s *Sink) *Sink {
+	sinks.Lock()
+	defer sinks.Unlock()
+
+	sinks.list = append(sinks.list, s)
+
+	return s
+}
+
+// RemoveSink stops the global logger logging to the given Sink, and closes any
+// file it was logging to, returning any error from closing it. It does nothing
+// if the Sink was already removed.
+//
+// The Sink is closed after it has been removed, so other logging isn't held up
+// while waiting for an asynchronous Sink to finish writing.
+func RemoveSink(s *Sink) error {
+	if !removeSink(s) {
+		return nil
+	}
+
+	return s.close()
+}
+
+// removeSink removes the given Sink from our sinks, returning true if it was
+// there.
+func removeSink(s *Sink) bool {
+	sinks.Lock()
+	defer sinks.Unlock()
+
+	for i, existing := range sinks.list {
+		if existing == s {
+			sinks.list = append(sinks.list[:i:i], sinks.list[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// setSink makes the given Sink the only Sink of the global logger, removing and
+// then closing all others. Errors from closing them are ignored, since there's
+// nothing useful that can be done about them, and nowhere left to log them.
+func setSink(s *Sink) {
+	for _, old := range replaceSinks(s) {
+		old.close()
+	}
+}
+
+// replaceSinks makes the given Sink our only Sink, returning the ones it
+// replaced.
+func replaceSinks(s *Sink) []*Sink {
+	sinks.Lock()
+	defer sinks.Unlock()
+
+	old := sinks.list
+	sinks.list = []*Sink{s}
+
+	return old
+}
+
+// fileHandler returns a handler that logs in the given format to a file at the
+// given path, which is created if necessary and appended to, and is rotated
+// according to the given Rotation. Also returns the opened file so that it can
+// be closed.
+func fileHandler(path string, format Format, rotation Rotation) (log.Handler, io.Closer, error) {
+	f, err := openRotatingFile(path, rotation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return log.StreamHandler(f, format.log15Format()), f, nil
+}
@@ -0,0 +1,77 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"log/syslog"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// syslogFacility is the facility our syslog messages are logged under.
+const syslogFacility = syslog.LOG_USER
+
+// AddSyslogSink is like AddSink(), but logs to a syslog daemon with the given
+// tag. Records are sent with a syslog severity matching their level.
+//
+// If network is blank, the local syslog daemon is used. Otherwise network and
+// raddr are as for net.Dial(), eg. "udp" and "host:514". The connection is
+// closed when the Sink is removed.
+func AddSyslogSink(network, raddr, tag, lvl string, format Format) (*Sink, error) {
+	w, err := syslog.Dial(network, raddr, syslogFacility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return addSink(newSink(syslogHandler(w, format), lvlFromString(lvl), w)), nil
+}
+
+// syslogHandler returns a handler that writes records in the given format to
+// the given syslog writer, at a severity corresponding to their level.
+func syslogHandler(w *syslog.Writer, format Format) log.Handler {
+	fmtr := format.log15Format()
+
+	return log.FuncHandler(func(r *log.Record) error {
+		msg := strings.TrimSpace(string(fmtr.Format(r)))
+
+		switch r.Lvl {
+		case log.LvlCrit:
+			return w.Crit(msg)
+		case log.LvlError:
+			return w.Err(msg)
+		case log.LvlWarn:
+			return w.Warning(msg)
+		case log.LvlInfo:
+			return w.Info(msg)
+		default:
+			return w.Debug(msg)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyslogSink(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("You can add a Sink that logs to a syslog daemon", t, func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		ToBufferAtLevel("crit")
+		defer ToDefault()
+
+		sink, err := AddSyslogSink("udp", conn.LocalAddr().String(), "wrtest", "info", FormatLogfmt)
+		So(err, ShouldBeNil)
+
+		Debug(ctx, "debug msg")
+		Error(ctx, "error msg", "foo", 1)
+
+		buf := make([]byte, 1024)
+		err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		So(err, ShouldBeNil)
+		n, _, err := conn.ReadFrom(buf)
+		So(err, ShouldBeNil)
+
+		msg := string(buf[:n])
+		So(msg, ShouldStartWith, "<11>")
+		So(msg, ShouldContainSubstring, "wrtest")
+		So(msg, ShouldContainSubstring, "msg=\"error msg\" foo=1")
+		So(strings.Contains(msg, "debug msg"), ShouldBeFalse)
+
+		So(RemoveSink(sink), ShouldBeNil)
+	})
+
+	Convey("You can't add a syslog Sink with a bad address", t, func() {
+		_, err := AddSyslogSink("foo", "bar", "wrtest", "info", FormatLogfmt)
+		So(err, ShouldNotBeNil)
+	})
+}
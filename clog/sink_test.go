@@ -0,0 +1,127 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package clog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/internal"
+)
+
+func TestSinks(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("With logging to a buffer at warn level", t, func() {
+		warnBuff := ToBufferAtLevel("warn")
+
+		Convey("You can add more Sinks with their own levels and formats", func() {
+			debugBuff := new(bytes.Buffer)
+			debugSink := AddSink(debugBuff, "debug", FormatJSON)
+
+			logPath := internal.FilePathInTempDir(t, "clog.log")
+			fileSink, err := AddFileSink(logPath, "info", FormatLogfmt)
+			So(err, ShouldBeNil)
+
+			Debug(ctx, "debug msg")
+			Info(ctx, "info msg")
+			Warn(ctx, "warn msg")
+
+			So(warnBuff.String(), ShouldNotContainSubstring, "debug msg")
+			So(warnBuff.String(), ShouldNotContainSubstring, "info msg")
+			So(warnBuff.String(), ShouldContainSubstring, "msg=\"warn msg\"")
+
+			So(debugBuff.String(), ShouldContainSubstring, `"msg":"debug msg"`)
+			So(debugBuff.String(), ShouldContainSubstring, `"msg":"info msg"`)
+			So(debugBuff.String(), ShouldContainSubstring, `"msg":"warn msg"`)
+			So(debugBuff.String(), ShouldContainSubstring, `"caller":"clog.go:`)
+
+			logs := internal.FileAsString(logPath)
+			So(logs, ShouldNotContainSubstring, "debug msg")
+			So(logs, ShouldContainSubstring, "msg=\"info msg\"")
+			So(logs, ShouldContainSubstring, "msg=\"warn msg\"")
+
+			Convey("And remove them again", func() {
+				So(RemoveSink(debugSink), ShouldBeNil)
+				So(RemoveSink(debugSink), ShouldBeNil)
+				So(RemoveSink(fileSink), ShouldBeNil)
+				debugBuff.Reset()
+
+				Warn(ctx, "later msg")
+				So(warnBuff.String(), ShouldContainSubstring, "later msg")
+				So(debugBuff.String(), ShouldBeBlank)
+				So(internal.FileAsString(logPath), ShouldNotContainSubstring, "later msg")
+			})
+
+			Convey("The To* functions replace all Sinks", func() {
+				buff := ToBufferAtLevel("debug")
+				debugBuff.Reset()
+
+				Warn(ctx, "later msg")
+				So(buff.String(), ShouldContainSubstring, "later msg")
+				So(debugBuff.String(), ShouldBeBlank)
+				So(internal.FileAsString(logPath), ShouldNotContainSubstring, "later msg")
+			})
+		})
+
+		Convey("You can't add a file Sink given a bad path", func() {
+			_, err := AddFileSink("!/*&^%$", "debug", FormatLogfmt)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("You can add and remove Sinks while logging concurrently", func() {
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					for j := 0; j < 100; j++ {
+						Warn(ctx, "msg")
+					}
+				}()
+			}
+
+			for i := 0; i < 10; i++ {
+				sink := AddSink(os.Stderr, "crit", FormatLogfmt)
+				So(RemoveSink(sink), ShouldBeNil)
+			}
+
+			wg.Wait()
+			So(warnBuff.String(), ShouldContainSubstring, "msg=msg")
+		})
+
+		Reset(func() {
+			ToDefault()
+		})
+	})
+}
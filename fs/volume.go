@@ -31,6 +31,7 @@ import (
 	"errors"
 
 	"github.com/wtsi-ssg/wr/backoff"
+	"github.com/wtsi-ssg/wr/metrics"
 	"github.com/wtsi-ssg/wr/retry"
 )
 
@@ -39,6 +40,17 @@ const mb100 uint64 = 104857600 // 100MB in bytes
 
 var errZeroBytes = errors.New("zero bytes claimed")
 
+// sizeGauge returns the Gauge in metrics.Default of volume sizes.
+func sizeGauge() *metrics.Gauge {
+	return metrics.Default.Gauge("wr_fs_volume_size_bytes", "Size of the volume last seen by Volume.Size().", "dir")
+}
+
+// freeGauge returns the Gauge in metrics.Default of volume free space.
+func freeGauge() *metrics.Gauge {
+	return metrics.Default.Gauge("wr_fs_volume_free_bytes",
+		"Free space on the volume last seen by Volume.NoSpaceLeft().", "dir")
+}
+
 // VolumeUsageCalculator has methods that provide volume usage information.
 type VolumeUsageCalculator interface {
 	// Size returns the size of the volume in bytes.
@@ -58,15 +70,23 @@ type Volume struct {
 	UsageCalculator VolumeUsageCalculator
 }
 
-// Size returns the size of the volume in GB.
+// Size returns the size of the volume in GB. The size in bytes is recorded in
+// a gauge in metrics.Default, labelled with Dir.
 func (v *Volume) Size(ctx context.Context) int {
-	return int(v.UsageCalculator.Size(ctx, v.Dir) / gb)
+	size := v.UsageCalculator.Size(ctx, v.Dir)
+	sizeGauge().Set(float64(size), v.Dir)
+
+	return int(size / gb)
 }
 
 // NoSpaceLeft tells you if the volume has no more space left (or is within
-// 100MB of being full).
+// 100MB of being full). The free space in bytes is recorded in a gauge in
+// metrics.Default, labelled with Dir.
 func (v *Volume) NoSpaceLeft(ctx context.Context) bool {
-	return v.UsageCalculator.Free(ctx, v.Dir) < mb100
+	free := v.UsageCalculator.Free(ctx, v.Dir)
+	freeGauge().Set(float64(free), v.Dir)
+
+	return free < mb100
 }
 
 // CachedVolumeUsageCalculator wraps a VolumeUsageCalculator to provide an
@@ -129,38 +149,32 @@ func retryIfZero(ctx context.Context,
 	backoff *backoff.Backoff,
 	f volumeUsageCalculationMethod,
 	arg string) uint64 {
-	var bytes uint64
-	status := retry.Do(
+	bytes, status := retry.DoValue(
 		ctx,
-		operationReturnsErrIfZero(ctx, f, arg, &bytes),
+		func() (uint64, error) {
+			bytes := f(ctx, arg)
+			if bytes == 0 {
+				return bytes, errZeroBytes
+			}
+
+			return bytes, nil
+		},
 		&retry.Untils{
-			&retry.UntilNoError{},
+			&retry.UntilValue[uint64]{Accept: isNonZero},
 			&retry.UntilLimit{Max: retries},
 		},
 		backoff,
 		"getting volume usage",
 	)
 
-	if status.StoppedBecause == retry.BecauseErrorNil {
+	if status.StoppedBecause == retry.BecauseValueAccepted {
 		backoff.Reset()
 	}
 
 	return bytes
 }
 
-// operationReturnsErrIfZero creates a retry.Operation that errors if supplied
-// method returns zero given supplied arg. The return value is stored in the
-// given bytes arg.
-func operationReturnsErrIfZero(ctx context.Context,
-	f volumeUsageCalculationMethod,
-	arg string,
-	bytes *uint64) retry.Operation {
-	return func() error {
-		*bytes = f(ctx, arg)
-		if *bytes == 0 {
-			return errZeroBytes
-		}
-
-		return nil
-	}
+// isNonZero returns true if bytes is greater than zero.
+func isNonZero(bytes uint64) bool {
+	return bytes > 0
 }
@@ -35,6 +35,8 @@ import (
 	"github.com/wtsi-ssg/wr/backoff"
 	bm "github.com/wtsi-ssg/wr/backoff/mock"
 	"github.com/wtsi-ssg/wr/fs/mock"
+	"github.com/wtsi-ssg/wr/metrics"
+	"github.com/wtsi-ssg/wr/retry"
 )
 
 func TestVolume(t *testing.T) {
@@ -50,6 +52,7 @@ func TestVolume(t *testing.T) {
 		}
 		volume := &Volume{Dir: path, UsageCalculator: m}
 		So(volume.Size(ctx), ShouldEqual, expectedSize)
+		So(sizeGauge().Value(path), ShouldEqual, gb)
 		So(volume.Size(ctx), ShouldEqual, expectedSize)
 		So(m.SizeInvoked, ShouldEqual, 2)
 
@@ -71,6 +74,7 @@ func TestVolume(t *testing.T) {
 		}
 		volume := &Volume{Dir: path, UsageCalculator: m}
 		So(volume.NoSpaceLeft(ctx), ShouldBeTrue)
+		So(freeGauge().Value(path), ShouldEqual, 5)
 
 		Convey("This result is not cached by a CachedVolumeUsageCalculator", func() {
 			m.FreeInvoked = 0
@@ -114,8 +118,14 @@ func TestVolume(t *testing.T) {
 		volume, m, _ := makeCheckedMockVolumeAndCalculator(attempts, 0*time.Millisecond, 0*time.Millisecond)
 
 		Convey("Free space is checked multiple times if 0", func() {
+			gaveUp := metrics.Default.Counter("wr_retry_gave_up_total", "")
+			before := gaveUp.Value("getting volume usage", string(retry.BecauseLimitReached))
 			So(volume.NoSpaceLeft(ctx), ShouldBeTrue)
 			So(m.FreeInvoked, ShouldEqual, attempts)
+
+			Convey("And the retries giving up are counted", func() {
+				So(gaveUp.Value("getting volume usage", string(retry.BecauseLimitReached)), ShouldEqual, before+1)
+			})
 		})
 
 		Convey("You can choose the number of attempts when checking", func() {
@@ -0,0 +1,351 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package metrics is used to record counters, gauges and histograms, and to
+// expose them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// kind is the type of our *Kind constants.
+type kind string
+
+// *Kind constants are the types of metric we support, named as in the
+// Prometheus text format.
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// labelSeparator separates label values in series keys; it can't appear in
+// valid UTF-8.
+const labelSeparator = "\xff"
+
+// DefaultBuckets returns the upper bounds of the histogram buckets used when
+// you don't supply any, suitable for durations in seconds.
+func DefaultBuckets() []float64 {
+	return []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+}
+
+// Default is the Registry that this repo's packages record their metrics in.
+var Default = NewRegistry() //nolint:gochecknoglobals // shared by all our packages
+
+// Registry holds a set of named metrics. It is concurrent safe.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter returns the Counter with the given name, creating it with the given
+// help text and label names if it doesn't exist yet. Counters can only go up.
+//
+// Names should follow Prometheus conventions, eg. "wr_retry_attempts_total".
+// It is a programming error to use the same name for different kinds of metric,
+// and that will panic.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.family(name, help, counterKind, labelNames, nil)}
+}
+
+// Gauge is like Counter(), but for a Gauge, which can go up and down.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.family(name, help, gaugeKind, labelNames, nil)}
+}
+
+// Histogram is like Counter(), but for a Histogram, which counts observations
+// in buckets with the given upper bounds. If buckets is empty, DefaultBuckets()
+// are used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets()
+	}
+
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &Histogram{r.family(name, help, histogramKind, labelNames, sorted)}
+}
+
+// family returns the existing family with the given name, or creates and
+// stores a new one. Existing families are looked up under a read lock, so that
+// recording metrics doesn't contend on the Registry.
+func (r *Registry) family(name, help string, k kind, labelNames []string, buckets []float64) *family {
+	if f := r.existingFamily(name, k); f != nil {
+		return f
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f := r.lookupFamily(name, k); f != nil {
+		return f
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       k,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+
+	return f
+}
+
+// existingFamily calls lookupFamily() while holding our read lock.
+func (r *Registry) existingFamily(name string, k kind) *family {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.lookupFamily(name, k)
+}
+
+// lookupFamily returns the family with the given name, or nil if there
+// isn't one. Panics if it isn't of the given kind. You must hold our lock.
+func (r *Registry) lookupFamily(name string, k kind) *family {
+	f, exists := r.families[name]
+	if !exists {
+		return nil
+	}
+
+	if f.kind != k {
+		panic(fmt.Sprintf("metric %s is a %s, not a %s", name, f.kind, k))
+	}
+
+	return f
+}
+
+// sortedFamilies returns our families sorted by name.
+func (r *Registry) sortedFamilies() []*family {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families
+}
+
+// family holds all the series of a named metric, one per distinct set of label
+// values.
+type family struct {
+	name       string
+	help       string
+	kind       kind
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series holds the value of a metric for one set of label values.
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// update calls fn with the series for the given label values while holding our
+// lock, creating the series if necessary. Missing label values are treated as
+// blank, and extra ones are ignored.
+func (f *family) update(labelValues []string, fn func(s *series)) {
+	values := f.normaliseLabelValues(labelValues)
+	key := strings.Join(values, labelSeparator)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labelValues: values}
+		if f.kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[key] = s
+	}
+
+	fn(s)
+}
+
+// read calls fn with a copy of the series for the given label values, which is
+// zero if it doesn't exist.
+func (f *family) read(labelValues []string, fn func(s series)) {
+	key := strings.Join(f.normaliseLabelValues(labelValues), labelSeparator)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, exists := f.series[key]; exists {
+		fn(*s)
+
+		return
+	}
+
+	fn(series{})
+}
+
+// normaliseLabelValues returns labelValues with exactly as many entries as we
+// have labelNames.
+func (f *family) normaliseLabelValues(labelValues []string) []string {
+	values := make([]string, len(f.labelNames))
+	copy(values, labelValues)
+
+	return values
+}
+
+// Counter is a metric that can only go up, eg. the number of retries. Get one
+// from Registry.Counter().
+type Counter struct {
+	f *family
+}
+
+// Inc adds 1 to the Counter for the given label values, which should
+// correspond to the Counter's label names.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the Counter for the given label values. Negative values are
+// ignored, since Counters can only go up.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+
+	c.f.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Value returns the current value of the Counter for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	var v float64
+
+	c.f.read(labelValues, func(s series) {
+		v = s.value
+	})
+
+	return v
+}
+
+// Gauge is a metric that can go up and down, eg. free disk space. Get one from
+// Registry.Gauge().
+type Gauge struct {
+	f *family
+}
+
+// Set sets the Gauge to v for the given label values, which should correspond
+// to the Gauge's label names.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+// Add adds v (which may be negative) to the Gauge for the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Value returns the current value of the Gauge for the given label values.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	var v float64
+
+	g.f.read(labelValues, func(s series) {
+		v = s.value
+	})
+
+	return v
+}
+
+// Histogram is a metric that counts observations, eg. sleep durations, in
+// buckets. Get one from Registry.Histogram().
+type Histogram struct {
+	f *family
+}
+
+// Observe records the observation v for the given label values, which should
+// correspond to the Histogram's label names.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		for i, upper := range h.f.buckets {
+			if v <= upper {
+				s.counts[i]++
+
+				break
+			}
+		}
+
+		s.count++
+		s.sum += v
+	})
+}
+
+// Count returns the number of observations made for the given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	var count uint64
+
+	h.f.read(labelValues, func(s series) {
+		count = s.count
+	})
+
+	return count
+}
+
+// Sum returns the sum of the observations made for the given label values.
+func (h *Histogram) Sum(labelValues ...string) float64 {
+	var sum float64
+
+	h.f.read(labelValues, func(s series) {
+		sum = s.sum
+	})
+
+	return sum
+}
@@ -0,0 +1,114 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package metrics
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Given a Registry", t, func() {
+		r := NewRegistry()
+
+		Convey("Counters go up, per label values", func() {
+			c := r.Counter("calls_total", "Calls.", "activity")
+			So(c.Value("foo"), ShouldEqual, 0)
+
+			c.Inc("foo")
+			c.Add(2.5, "foo")
+			c.Add(-1, "foo")
+			c.Inc("bar")
+			So(c.Value("foo"), ShouldEqual, 3.5)
+			So(c.Value("bar"), ShouldEqual, 1)
+
+			Convey("Getting the same name again returns the same metric", func() {
+				So(r.Counter("calls_total", "Calls.", "activity").Value("foo"), ShouldEqual, 3.5)
+			})
+
+			Convey("Missing label values are blank and extras are ignored", func() {
+				c.Inc()
+				c.Inc("", "extra")
+				So(c.Value(""), ShouldEqual, 2)
+			})
+
+			Convey("Using the name for a different kind panics", func() {
+				So(func() { r.Gauge("calls_total", "Calls.") }, ShouldPanic)
+			})
+		})
+
+		Convey("Gauges go up and down", func() {
+			g := r.Gauge("free_bytes", "Free.", "dir")
+			g.Set(10, "/a")
+			g.Add(-3, "/a")
+			g.Add(2, "/b")
+			So(g.Value("/a"), ShouldEqual, 7)
+			So(g.Value("/b"), ShouldEqual, 2)
+		})
+
+		Convey("Histograms count observations", func() {
+			h := r.Histogram("sleep_seconds", "Sleeps.", []float64{1, 0.1})
+			So(h.f.buckets, ShouldResemble, []float64{0.1, 1})
+
+			h.Observe(0.05)
+			h.Observe(0.5)
+			h.Observe(5)
+			So(h.Count(), ShouldEqual, 3)
+			So(h.Sum(), ShouldEqual, 5.55)
+			So(h.f.series[""].counts, ShouldResemble, []uint64{1, 1})
+
+			So(r.Histogram("default_seconds", "Defaults.", nil).f.buckets, ShouldResemble, DefaultBuckets())
+		})
+
+		Convey("Metrics are concurrent safe", func() {
+			c := r.Counter("calls_total", "Calls.")
+			h := r.Histogram("sleep_seconds", "Sleeps.", nil)
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					for j := 0; j < 100; j++ {
+						c.Inc()
+						h.Observe(1)
+						r.Counter("lookups_total", "Lookups.").Inc()
+					}
+				}()
+			}
+
+			wg.Wait()
+			So(c.Value(), ShouldEqual, 1000)
+			So(h.Count(), ShouldEqual, 1000)
+			So(r.Counter("lookups_total", "Lookups.").Value(), ShouldEqual, 1000)
+		})
+	})
+}
@@ -0,0 +1,164 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// textContentType is the content type of the Prometheus text format.
+const textContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelValueEscaper escapes label values in the Prometheus text format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`) //nolint:gochecknoglobals // immutable
+
+// helpEscaper escapes help text in the Prometheus text format.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`) //nolint:gochecknoglobals // immutable
+
+// WriteText writes all our metrics to w in the Prometheus text exposition
+// format, sorted by name and then by label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range r.sortedFamilies() {
+		f.writeText(bw)
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler that responds with WriteText(), for serving
+// on eg. /metrics to be scraped by Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", textContentType)
+
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Handler returns Default.Handler().
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// writeText writes our HELP and TYPE lines, followed by all our series.
+func (f *family) writeText(w *bufio.Writer) {
+	w.WriteString("# HELP " + f.name + " " + helpEscaper.Replace(f.help) + "\n")
+	w.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+
+	for _, s := range f.sortedSeries() {
+		if f.kind == histogramKind {
+			f.writeHistogramText(w, s)
+
+			continue
+		}
+
+		f.writeSample(w, f.name, s.labelValues, "", "", s.value)
+	}
+}
+
+// sortedSeries returns copies of our series sorted by label values.
+func (f *family) sortedSeries() []series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	sorted := make([]series, len(keys))
+
+	for i, key := range keys {
+		s := *f.series[key]
+		s.counts = append([]uint64(nil), s.counts...)
+		sorted[i] = s
+	}
+
+	return sorted
+}
+
+// writeHistogramText writes the cumulative bucket counts, sum and count of the
+// given histogram series.
+func (f *family) writeHistogramText(w *bufio.Writer, s series) {
+	var cumulative uint64
+
+	for i, upper := range f.buckets {
+		cumulative += s.counts[i]
+		f.writeSample(w, f.name+"_bucket", s.labelValues, "le", formatFloat(upper), float64(cumulative))
+	}
+
+	f.writeSample(w, f.name+"_bucket", s.labelValues, "le", "+Inf", float64(s.count))
+	f.writeSample(w, f.name+"_sum", s.labelValues, "", "", s.sum)
+	f.writeSample(w, f.name+"_count", s.labelValues, "", "", float64(s.count))
+}
+
+// writeSample writes a line with the given name, our labels with the given
+// values, an optional extra label, and the given value.
+func (f *family) writeSample(w *bufio.Writer, name string, labelValues []string, extraName, extraValue string,
+	value float64) {
+	w.WriteString(name)
+
+	labels := make([]string, 0, len(labelValues)+1)
+	for i, v := range labelValues {
+		labels = append(labels, f.labelNames[i]+`="`+labelValueEscaper.Replace(v)+`"`)
+	}
+
+	if extraName != "" {
+		labels = append(labels, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(labels) > 0 {
+		w.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatFloat formats v as in the Prometheus text format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
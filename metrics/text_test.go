@@ -0,0 +1,106 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const expectedText = `# HELP calls_total Number of "calls".
+# TYPE calls_total counter
+calls_total{activity="bar",reason="a \"quoted\\\" \nreason"} 1
+calls_total{activity="foo",reason=""} 2
+# HELP free_bytes Free space\nin bytes.
+# TYPE free_bytes gauge
+free_bytes 1.5e+10
+# HELP sleep_seconds Sleeps.
+# TYPE sleep_seconds histogram
+sleep_seconds_bucket{dir="/a",le="0.1"} 1
+sleep_seconds_bucket{dir="/a",le="1"} 1
+sleep_seconds_bucket{dir="/a",le="+Inf"} 2
+sleep_seconds_sum{dir="/a"} 5.05
+sleep_seconds_count{dir="/a"} 2
+`
+
+func TestText(t *testing.T) {
+	Convey("Given a Registry with some metrics", t, func() {
+		r := NewRegistry()
+		r.Histogram("sleep_seconds", "Sleeps.", []float64{0.1, 1}, "dir").Observe(0.05, "/a")
+		r.Histogram("sleep_seconds", "Sleeps.", nil, "dir").Observe(5, "/a")
+		r.Gauge("free_bytes", "Free space\nin bytes.").Set(15e9)
+
+		c := r.Counter("calls_total", `Number of "calls".`, "activity", "reason")
+		c.Inc("foo")
+		c.Inc("foo")
+		c.Inc("bar", "a \"quoted\\\" \nreason")
+
+		Convey("You can write them in Prometheus text format", func() {
+			buff := new(bytes.Buffer)
+			err := r.WriteText(buff)
+			So(err, ShouldBeNil)
+			So(buff.String(), ShouldEqual, expectedText)
+		})
+
+		Convey("You can serve them over HTTP", func() {
+			server := httptest.NewServer(r.Handler())
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, textContentType)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, expectedText)
+		})
+	})
+
+	Convey("The package Handler() serves the Default Registry", t, func() {
+		c := Default.Counter("wr_test_total", "Test.")
+		c.Inc()
+
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		So(rec.Body.String(), ShouldContainSubstring, "wr_test_total "+formatFloat(c.Value())+"\n")
+	})
+
+	Convey("formatFloat handles special values", t, func() {
+		So(formatFloat(math.Inf(1)), ShouldEqual, "+Inf")
+		So(formatFloat(math.Inf(-1)), ShouldEqual, "-Inf")
+		So(formatFloat(math.NaN()), ShouldEqual, "NaN")
+		So(formatFloat(0.25), ShouldEqual, "0.25")
+	})
+}
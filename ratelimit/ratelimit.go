@@ -0,0 +1,181 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package ratelimit is used to limit the rate at which something is done, even
+// across many goroutines.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/wtsi-ssg/wr/backoff"
+	btime "github.com/wtsi-ssg/wr/backoff/time"
+	"github.com/wtsi-ssg/wr/clog"
+)
+
+// logger is used for all our logging, as the "ratelimit" subsystem.
+const logger clog.Logger = "ratelimit"
+
+// never is the wait returned by durationFor() when tokens are never added.
+const never = time.Duration(math.MaxInt64)
+
+// Limiter is a token bucket rate limiter. The bucket starts full with Burst
+// tokens, and refills at Rate tokens per second. Each Wait() takes a token,
+// waiting for one to become available if necessary. With a Burst of 1 it
+// behaves as a leaky bucket, spacing calls out evenly at Rate per second.
+//
+// It is concurrent safe, and is intended to be shared by everything that must
+// collectively not exceed the rate. It can gate retry.Do() attempts via the
+// retry.AttemptGate() Option.
+type Limiter struct {
+	// Rate is the number of tokens added to the bucket per second. A Rate of
+	// 0 or less means no tokens are ever added, so once the initial Burst has
+	// been used up, Wait() only returns when its context is cancelled.
+	Rate float64
+
+	// Burst is the maximum number of tokens in the bucket. 0 is treated as 1.
+	Burst int
+
+	// Clock is used to tell the time and to wait. If nil, real time is used.
+	// Supply a backoff/mock.Clock for testing.
+	Clock backoff.Clock
+
+	tokens  float64
+	last    time.Time
+	started bool
+	mu      sync.Mutex
+}
+
+// Wait takes a token from the bucket, waiting until one is available. If the
+// context is cancelled before then, the token is given back and the context's
+// error is returned.
+//
+// Waits are logged using the "ratelimit" clog subsystem at debug level.
+func (l *Limiter) Wait(ctx context.Context) error {
+	d := l.reserve()
+	if d <= 0 {
+		return nil
+	}
+
+	logger.Debug(ctx, "rate limited", "wait", d)
+	l.sleep(ctx, d)
+
+	if err := ctx.Err(); err != nil {
+		l.cancelReservation()
+
+		return err
+	}
+
+	return nil
+}
+
+// reserve takes a token, returning how long to wait until that token would
+// have become available.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return l.durationFor(-l.tokens)
+}
+
+// sleep sleeps for d using our clock, or until the context is cancelled if d
+// is never.
+func (l *Limiter) sleep(ctx context.Context, d time.Duration) {
+	if d == never {
+		<-ctx.Done()
+
+		return
+	}
+
+	l.clock().Sleep(ctx, d)
+}
+
+// cancelReservation gives back a token taken by reserve().
+func (l *Limiter) cancelReservation() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+}
+
+// refill adds the tokens that have accumulated since the last refill. You must
+// hold the lock before calling this.
+func (l *Limiter) refill() {
+	now := l.clock().Now()
+
+	switch {
+	case !l.started:
+		l.tokens = float64(l.burst())
+		l.started = true
+	case l.Rate > 0:
+		l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*l.Rate, float64(l.burst()))
+	}
+
+	l.last = now
+}
+
+// durationFor returns how long it takes to accumulate the given number of
+// tokens, which is never if our Rate is 0 or less (or so small that the
+// duration would overflow).
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	if l.Rate <= 0 {
+		return never
+	}
+
+	d := tokens / l.Rate * float64(time.Second)
+	if d >= float64(never) {
+		return never
+	}
+
+	return time.Duration(d)
+}
+
+// burst returns our Burst, treating 0 as 1.
+func (l *Limiter) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+
+	return l.Burst
+}
+
+// clock returns our Clock, defaulting to real time.
+func (l *Limiter) clock() backoff.Clock {
+	if l.Clock == nil {
+		return &btime.Sleeper{}
+	}
+
+	return l.Clock
+}
@@ -0,0 +1,182 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/retry"
+)
+
+var errOp = errors.New("op err")
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Limiter implements retry.Gate", t, func() {
+		var _ retry.Gate = (*Limiter)(nil)
+	})
+
+	Convey("Given a Limiter with a Burst", t, func() {
+		clock := bm.NewClock(time.Now())
+		l := &Limiter{Rate: 10, Burst: 2, Clock: clock}
+
+		wait := func(ctx context.Context) chan error {
+			errCh := make(chan error, 1)
+
+			go func() {
+				errCh <- l.Wait(ctx)
+			}()
+
+			return errCh
+		}
+
+		Convey("You can Wait() for Burst tokens without waiting", func() {
+			So(l.Wait(ctx), ShouldBeNil)
+			So(l.Wait(ctx), ShouldBeNil)
+			So(clock.Sleepers(), ShouldEqual, 0)
+
+			Convey("Then you have to wait for a token to be added", func() {
+				buff := clog.ToBufferAtLevel("debug")
+				defer clog.ToDefault()
+
+				errCh := wait(ctx)
+				So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+				clock.Advance(100 * time.Millisecond)
+				So(<-errCh, ShouldBeNil)
+				So(buff.String(), ShouldContainSubstring, "msg=\"rate limited\" wait=100ms")
+
+				Convey("And concurrent waiters queue up", func() {
+					errCh1 := wait(ctx)
+					So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+					errCh2 := wait(ctx)
+					So(clock.WaitForSleepers(ctx, 2), ShouldBeTrue)
+
+					clock.Advance(100 * time.Millisecond)
+					So(<-errCh1, ShouldBeNil)
+					So(clock.Sleepers(), ShouldEqual, 1)
+
+					clock.Advance(100 * time.Millisecond)
+					So(<-errCh2, ShouldBeNil)
+				})
+			})
+
+			Convey("Tokens refill up to Burst over time", func() {
+				clock.Advance(1 * time.Second)
+				So(l.Wait(ctx), ShouldBeNil)
+				So(l.Wait(ctx), ShouldBeNil)
+				So(clock.Sleepers(), ShouldEqual, 0)
+			})
+
+			Convey("Waiting can be cancelled, giving the token back", func() {
+				cctx, cancel := context.WithCancel(ctx)
+				errCh := wait(cctx)
+				So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+				cancel()
+				So(<-errCh, ShouldEqual, context.Canceled)
+
+				errCh = wait(ctx)
+				So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+				clock.Advance(100 * time.Millisecond)
+				So(<-errCh, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("A Limiter uses real time by default", t, func() {
+		l := &Limiter{Rate: 100}
+		start := time.Now()
+		So(l.Wait(ctx), ShouldBeNil)
+		So(l.Wait(ctx), ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 9*time.Millisecond)
+	})
+
+	Convey("A Limiter with no Rate never adds tokens", t, func() {
+		l := &Limiter{}
+		So(l.Wait(ctx), ShouldBeNil)
+
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		So(errors.Is(l.Wait(timeout), context.DeadlineExceeded), ShouldBeTrue)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 9*time.Millisecond)
+		So(l.durationFor(1), ShouldEqual, never)
+
+		l.Rate = 1e-12
+		So(l.durationFor(1), ShouldEqual, never)
+	})
+
+	Convey("A Limiter can gate retry.Do attempts", t, func() {
+		clock := bm.NewClock(time.Now())
+		l := &Limiter{Rate: 1, Clock: clock}
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		buff := clog.ToBufferAtLevel("debug")
+		defer clog.ToDefault()
+
+		statusCh := make(chan *retry.Status)
+
+		go func() {
+			statusCh <- retry.Do(ctx, func() error { return errOp }, &retry.UntilLimit{Max: 2}, bo, "doing foo",
+				retry.AttemptGate(l))
+		}()
+
+		for i := 0; i < 2; i++ {
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+			clock.Advance(1 * time.Second)
+		}
+
+		status := <-statusCh
+		So(status.Retried, ShouldEqual, 2)
+		So(status.Err, ShouldEqual, errOp)
+		So(buff.String(), ShouldContainSubstring, "retryactivity=\"doing foo\"")
+		So(buff.String(), ShouldContainSubstring, "msg=\"rate limited\"")
+
+		Convey("And cancelling while waiting stops the retries", func() {
+			cctx, cancel := context.WithCancel(ctx)
+
+			go func() {
+				statusCh <- retry.Do(cctx, func() error { return errOp }, &retry.UntilLimit{Max: 2}, bo, "doing foo",
+					retry.AttemptGate(l))
+			}()
+
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+			cancel()
+
+			status = <-statusCh
+			So(status.Retried, ShouldEqual, 0)
+			So(status.StoppedBecause, ShouldEqual, retry.BecauseContextClosed)
+			So(status.Err, ShouldEqual, context.Canceled)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"math"
+	"sync"
+)
+
+const budgetRetryThreshold = 2
+
+// Budget is a process-wide retry budget, which limits retries during outages
+// so that many independent callers of Do() don't cause a retry storm. It works
+// like gRPC's retry throttling: it holds up to MaxTokens tokens and starts full.
+// Every failed attempt removes 1 token and every successful attempt adds
+// TokenRatio tokens. Retries are only allowed while more than half of
+// MaxTokens remain.
+//
+// Use one Budget for all the Do() calls that should share it, either supplying
+// it with the WithBudget() Option, or using it as an Until. It is concurrent
+// safe.
+type Budget struct {
+	maxTokens  float64
+	tokenRatio float64
+	tokens     float64
+	mu         sync.Mutex
+}
+
+// NewBudget returns a full Budget with the given maximum number of tokens and
+// the given ratio of tokens added per successful attempt. For example, a
+// tokenRatio of 0.1 allows roughly 1 retry per 10 successful attempts once
+// the initial half of the tokens has been used.
+func NewBudget(maxTokens, tokenRatio float64) *Budget {
+	return &Budget{maxTokens: maxTokens, tokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+// Tokens returns the number of tokens currently in the Budget.
+func (b *Budget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens
+}
+
+// observeAttempt adjusts our tokens based on the error returned by an attempt.
+func (b *Budget) observeAttempt(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.tokens = math.Min(b.tokens+b.tokenRatio, b.maxTokens)
+
+		return
+	}
+
+	b.tokens = math.Max(b.tokens-1, 0)
+}
+
+// ShouldStop returns BecauseBudgetExhausted when err is not nil and no more
+// than half of our maximum tokens remain. retries is not considered.
+//
+// Attempts are recorded in the Budget by Do() regardless of where the Budget
+// is in an Untils. Outside of Do(), nothing is recorded.
+func (b *Budget) ShouldStop(retries int, err error) Reason {
+	if err != nil && !b.allowsRetry() {
+		return BecauseBudgetExhausted
+	}
+
+	return doNotStop
+}
+
+// allowsRetry returns true if more than half of our maximum tokens remain.
+func (b *Budget) allowsRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens > b.maxTokens/budgetRetryThreshold
+}
+
+// WithBudget returns an Option that makes Do() record its attempts in the
+// given Budget, and stop retrying with BecauseBudgetExhausted when the Budget
+// does not allow further retries.
+func WithBudget(budget *Budget) Option {
+	return func(o *options) {
+		o.untils = append(o.untils, budget)
+	}
+}
+
+// attemptObserver is implemented by Untils that want to know the error
+// returned by every attempt in Do(), even if an earlier Until in an Untils
+// decided to stop.
+type attemptObserver interface {
+	// observeAttempt is given the error returned by the latest attempt.
+	observeAttempt(err error)
+}
+
+// observeAttempt passes the given error to any of the elements of this slice
+// that want it.
+func (u Untils) observeAttempt(err error) {
+	for _, until := range u {
+		if observer, ok := until.(attemptObserver); ok {
+			observer.observeAttempt(err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+func TestBudget(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("A Budget allows retries while more than half its tokens remain", t, func() {
+		var _ Until = (*Budget)(nil)
+		b := NewBudget(4, 0.5)
+		So(b.Tokens(), ShouldEqual, 4)
+		So(b.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+
+		b.observeAttempt(ErrNormal)
+		So(b.Tokens(), ShouldEqual, 3)
+		So(b.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+
+		b.observeAttempt(ErrNormal)
+		So(b.Tokens(), ShouldEqual, 2)
+		So(b.ShouldStop(0, ErrNormal), ShouldEqual, BecauseBudgetExhausted)
+		So(b.ShouldStop(0, nil), ShouldEqual, doNotStop)
+
+		Convey("Successes refill it, up to the maximum", func() {
+			b.observeAttempt(nil)
+			So(b.Tokens(), ShouldEqual, 2.5)
+			So(b.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+
+			for i := 0; i < 10; i++ {
+				b.observeAttempt(nil)
+			}
+
+			So(b.Tokens(), ShouldEqual, 4)
+		})
+
+		Convey("Tokens don't go below 0", func() {
+			for i := 0; i < 10; i++ {
+				b.observeAttempt(ErrNormal)
+			}
+
+			So(b.Tokens(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("A Budget shared between Do() calls stops retry storms", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		b := NewBudget(10, 0.1)
+		op := func() error {
+			return ErrOp
+		}
+
+		status := Do(ctx, op, &UntilLimit{Max: 3}, bo, "doing foo", WithBudget(b))
+		So(status.Retried, ShouldEqual, 3)
+		So(status.StoppedBecause, ShouldEqual, BecauseLimitReached)
+		So(b.Tokens(), ShouldEqual, 6)
+
+		status = Do(ctx, op, &UntilLimit{Max: 3}, bo, "doing foo", WithBudget(b))
+		So(status.Retried, ShouldEqual, 0)
+		So(status.StoppedBecause, ShouldEqual, BecauseBudgetExhausted)
+		So(b.Tokens(), ShouldEqual, 5)
+
+		Convey("It records attempts even when used as an Until after one that stops", func() {
+			status = Do(ctx, func() error { return nil }, Untils{&UntilNoError{}, b}, bo, "doing foo")
+			So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+			So(b.Tokens(), ShouldEqual, 5.1)
+		})
+	})
+}
@@ -0,0 +1,136 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"errors"
+	"reflect"
+)
+
+// PermanentError wraps an error returned by an Operation to signal that
+// retrying would be pointless. Create one with Permanent().
+type PermanentError struct {
+	Err error
+}
+
+// Permanent wraps the given error in a PermanentError, so that Do() will stop
+// retrying with BecausePermanentError if your Operation returns it. A nil err
+// returns nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &PermanentError{Err: err}
+}
+
+// Error returns the message of our wrapped error.
+func (p *PermanentError) Error() string {
+	return p.Err.Error()
+}
+
+// Unwrap returns our wrapped error.
+func (p *PermanentError) Unwrap() error {
+	return p.Err
+}
+
+// ErrorMatcher reports if an error is one of interest.
+type ErrorMatcher func(err error) bool
+
+// ErrorIs returns an ErrorMatcher that matches errors for which errors.Is() is
+// true for any of the given targets.
+func ErrorIs(targets ...error) ErrorMatcher {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ErrorAs returns an ErrorMatcher that matches errors for which errors.As()
+// would be true given target. Like errors.As(), target must be a non-nil
+// pointer to a type that implements error, or to any interface type, else
+// this panics. target itself is never set.
+func ErrorAs(target interface{}) ErrorMatcher {
+	targetType := reflect.TypeOf(target).Elem()
+
+	return func(err error) bool {
+		return errors.As(err, reflect.New(targetType).Interface())
+	}
+}
+
+// UntilErrorMatches implements Until, stopping retries when the error passed to
+// ShouldStop is matched by Matcher, eg. for authentication failures that will
+// never succeed on retry.
+type UntilErrorMatches struct {
+	Matcher ErrorMatcher
+}
+
+// ShouldStop returns BecauseErrorMatched when err is non-nil and matched by
+// Matcher. retries is not considered.
+func (u *UntilErrorMatches) ShouldStop(retries int, err error) Reason {
+	if err != nil && u.Matcher(err) {
+		return BecauseErrorMatched
+	}
+
+	return doNotStop
+}
+
+// UntilErrorNotTransient implements Until, stopping retries when the error
+// passed to ShouldStop is not matched by Transient, so that only errors known
+// to be transient are retried.
+type UntilErrorNotTransient struct {
+	Transient ErrorMatcher
+}
+
+// ShouldStop returns BecauseErrorNotTransient when err is non-nil and not
+// matched by Transient. retries is not considered.
+func (u *UntilErrorNotTransient) ShouldStop(retries int, err error) Reason {
+	if err != nil && !u.Transient(err) {
+		return BecauseErrorNotTransient
+	}
+
+	return doNotStop
+}
+
+// untilPermanentError implements Until, stopping retries when the error passed
+// to ShouldStop is a PermanentError.
+type untilPermanentError struct{}
+
+// ShouldStop returns BecausePermanentError when err is or wraps a
+// PermanentError. retries is not considered.
+func (u *untilPermanentError) ShouldStop(retries int, err error) Reason {
+	var perr *PermanentError
+	if errors.As(err, &perr) {
+		return BecausePermanentError
+	}
+
+	return doNotStop
+}
@@ -0,0 +1,97 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var ErrAuth = errors.New("auth failed")
+
+type tempError struct{}
+
+func (e *tempError) Error() string {
+	return "temporary"
+}
+
+func TestErrors(t *testing.T) {
+	Convey("Permanent wraps errors", t, func() {
+		So(Permanent(nil), ShouldBeNil)
+
+		err := Permanent(ErrNormal)
+		So(err.Error(), ShouldEqual, ErrNormal.Error())
+		So(errors.Is(err, ErrNormal), ShouldBeTrue)
+
+		var perr *PermanentError
+		So(errors.As(err, &perr), ShouldBeTrue)
+	})
+
+	Convey("ErrorIs matches errors wrapping any of its targets", t, func() {
+		m := ErrorIs(ErrAuth, os.ErrNotExist)
+		So(m(ErrAuth), ShouldBeTrue)
+		So(m(fmt.Errorf("wrapped: %w", os.ErrNotExist)), ShouldBeTrue)
+		So(m(ErrNormal), ShouldBeFalse)
+		So(m(nil), ShouldBeFalse)
+	})
+
+	Convey("ErrorAs matches errors of the target's type, without setting it", t, func() {
+		var target *tempError
+		m := ErrorAs(&target)
+		So(m(fmt.Errorf("wrapped: %w", &tempError{})), ShouldBeTrue)
+		So(m(ErrNormal), ShouldBeFalse)
+		So(target, ShouldBeNil)
+	})
+
+	Convey("UntilErrorMatches stops on matching errors", t, func() {
+		var _ Until = (*UntilErrorMatches)(nil)
+		u := &UntilErrorMatches{Matcher: ErrorIs(ErrAuth)}
+		So(u.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+		So(u.ShouldStop(1, ErrAuth), ShouldEqual, BecauseErrorMatched)
+	})
+
+	Convey("UntilErrorNotTransient stops on errors that aren't transient", t, func() {
+		var _ Until = (*UntilErrorNotTransient)(nil)
+		var target *tempError
+		u := &UntilErrorNotTransient{Transient: ErrorAs(&target)}
+		So(u.ShouldStop(0, &tempError{}), ShouldEqual, doNotStop)
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+		So(u.ShouldStop(1, ErrNormal), ShouldEqual, BecauseErrorNotTransient)
+	})
+
+	Convey("untilPermanentError stops on Permanent errors", t, func() {
+		var _ Until = (*untilPermanentError)(nil)
+		u := &untilPermanentError{}
+		So(u.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+		So(u.ShouldStop(0, fmt.Errorf("wrapped: %w", Permanent(ErrNormal))), ShouldEqual, BecausePermanentError)
+	})
+}
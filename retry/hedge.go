@@ -0,0 +1,161 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+
+	"github.com/wtsi-ssg/wr/backoff"
+)
+
+// hedging holds the configuration supplied to Hedge().
+type hedging struct {
+	bo  *backoff.Backoff
+	max int
+}
+
+// Hedge returns an Option that makes each attempt by Do() a "hedged" one: if
+// the Operation hasn't returned after a delay from bo, a second concurrent
+// attempt is started, then a third after the next delay from bo, and so on, up
+// to max concurrent attempts. The first to succeed is used and the contexts of
+// the others are cancelled. If they all fail, the error of the last one to
+// fail is used as normal.
+//
+// This is intended for latency-sensitive ContextOperations passed to
+// DoContext(); other Operation types can't be cancelled. bo is Reset() at the
+// start of each attempt, so should not be shared. The returned Status reports
+// on the concurrent attempts.
+func Hedge(bo *backoff.Backoff, max int) Option {
+	return func(o *options) {
+		o.hedging = &hedging{bo: bo, max: max}
+	}
+}
+
+// hedgeResult holds the return values of one of the concurrent attempts made by
+// runHedged().
+type hedgeResult struct {
+	attemptResult
+	num int
+}
+
+// runOp runs our op once with a context derived from the given one, or hedges
+// it if configured to do so, returning its value and error.
+func (r *retrier) runOp(ctx context.Context) (interface{}, error) {
+	if r.opts.hedging == nil || r.opts.hedging.max < 2 {
+		return r.runSingle(ctx)
+	}
+
+	return r.runHedged(ctx)
+}
+
+// runHedged starts our op, and then starts more concurrent attempts after each
+// hedging backoff delay, returning the first success, or else the error of the
+// last to fail once all started attempts have failed.
+func (r *retrier) runHedged(parent context.Context) (interface{}, error) {
+	r.opts.hedging.bo.Reset()
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	results := make(chan hedgeResult, r.opts.hedging.max)
+	r.hedges, r.winner = 0, 0
+	finished := 0
+
+	r.startHedge(ctx, results)
+	delay := r.hedgeDelay(ctx)
+
+	for {
+		select {
+		case result := <-results:
+			finished++
+
+			if r.hedgeFinished(result, finished) {
+				return result.value, result.err
+			}
+		case <-delay:
+			delay = r.nextHedge(ctx, results)
+		}
+	}
+}
+
+// hedgeFinished returns true if runHedged() should return the given result of
+// the given number of finished attempts: because it succeeded, making it the
+// winner, or because it was the last of the started attempts to fail.
+func (r *retrier) hedgeFinished(result hedgeResult, finished int) bool {
+	if result.err == nil {
+		r.winner = result.num
+
+		return true
+	}
+
+	return finished == r.hedges
+}
+
+// nextHedge starts another concurrent attempt unless the context has been
+// cancelled, returning the delay before the one after that, if any.
+func (r *retrier) nextHedge(ctx context.Context, results chan hedgeResult) <-chan struct{} {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	r.startHedge(ctx, results)
+
+	return r.hedgeDelay(ctx)
+}
+
+// startHedge starts another concurrent attempt at running our op, sending the
+// result to the given channel.
+func (r *retrier) startHedge(ctx context.Context, results chan hedgeResult) {
+	r.hedges++
+	num := r.hedges
+
+	go func() {
+		value, err := r.runSingle(ctx)
+		results <- hedgeResult{attemptResult: attemptResult{value: value, err: err}, num: num}
+	}()
+}
+
+// hedgeDelay returns a channel that is closed after the next delay from our
+// hedging backoff, or when the context is cancelled. If no more concurrent
+// attempts are allowed, returns nil. The delay is slept on directly with the
+// backoff's Sleeper, since it isn't a retry backoff and so shouldn't be logged
+// or recorded as one.
+func (r *retrier) hedgeDelay(ctx context.Context) <-chan struct{} {
+	if r.hedges >= r.opts.hedging.max {
+		return nil
+	}
+
+	done := make(chan struct{})
+	bo := r.opts.hedging.bo
+	d := bo.Next(ctx, r.untils.budgetDeadline())
+
+	go func() {
+		bo.Sleeper.Sleep(ctx, d)
+		close(done)
+	}()
+
+	return done
+}
@@ -0,0 +1,145 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+	"github.com/wtsi-ssg/wr/metrics"
+)
+
+func TestHedge(t *testing.T) {
+	ctx := context.Background()
+	activity := "doing foo"
+
+	Convey("Given backoffs for retrying and hedging", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		clock := bm.NewClock(time.Now())
+		hedgeWait := 10 * time.Millisecond
+		hbo := &backoff.Backoff{Min: hedgeWait, Max: hedgeWait, Sleeper: clock}
+
+		Convey("A slow first attempt is hedged by a second, which wins and cancels the first", func() {
+			var count int32
+
+			firstStarted, firstCancelled := make(chan bool, 1), make(chan bool, 1)
+			op := func(ctx context.Context) error {
+				if atomic.AddInt32(&count, 1) == 1 {
+					firstStarted <- true
+					<-ctx.Done()
+					firstCancelled <- true
+
+					return ctx.Err()
+				}
+
+				return nil
+			}
+
+			sleeps := metrics.Default.Histogram("wr_backoff_sleep_seconds", "", nil)
+			sleepsBefore := sleeps.Count()
+			statusCh := make(chan *Status)
+
+			go func() {
+				statusCh <- DoContext(ctx, op, &UntilNoError{}, bo, activity, Hedge(hbo, 3))
+			}()
+
+			<-firstStarted
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+			clock.Advance(hedgeWait)
+
+			status := <-statusCh
+			So(status.Retried, ShouldEqual, 0)
+			So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+			So(status.Concurrent, ShouldEqual, 2)
+			So(status.Winner, ShouldEqual, 2)
+			So(<-firstCancelled, ShouldBeTrue)
+			So(sleeps.Count(), ShouldEqual, sleepsBefore)
+		})
+
+		Convey("A fast first attempt is not hedged", func() {
+			status := DoContext(ctx, func(ctx context.Context) error { return nil }, &UntilNoError{}, bo, activity,
+				Hedge(hbo, 3))
+			So(status.Concurrent, ShouldEqual, 1)
+			So(status.Winner, ShouldEqual, 1)
+		})
+
+		Convey("If all hedged attempts fail, the attempt fails and is retried as normal", func() {
+			var count int32
+
+			op := func(ctx context.Context) error {
+				atomic.AddInt32(&count, 1)
+
+				return ErrOp
+			}
+
+			status := DoContext(ctx, op, &UntilLimit{Max: 1}, bo, activity, Hedge(hbo, 3))
+			So(status.Retried, ShouldEqual, 1)
+			So(status.StoppedBecause, ShouldEqual, BecauseLimitReached)
+			So(status.Err, ShouldEqual, ErrOp)
+			So(status.Concurrent, ShouldEqual, 1)
+			So(status.Winner, ShouldEqual, 0)
+			So(atomic.LoadInt32(&count), ShouldEqual, 2)
+		})
+
+		Convey("No more than max concurrent attempts are started", func() {
+			started, release := make(chan bool, 3), make(chan bool)
+			op := func(ctx context.Context) error {
+				started <- true
+				<-release
+
+				return ErrOp
+			}
+
+			statusCh := make(chan *Status)
+
+			go func() {
+				statusCh <- DoContext(ctx, op, &UntilLimit{Max: 0}, bo, activity, Hedge(hbo, 2))
+			}()
+
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+			clock.Advance(hedgeWait)
+			<-started
+			<-started
+			close(release)
+
+			status := <-statusCh
+			So(status.Concurrent, ShouldEqual, 2)
+			So(status.Winner, ShouldEqual, 0)
+			So(clock.Sleepers(), ShouldEqual, 0)
+		})
+
+		Convey("Without Hedge(), Status doesn't report on concurrency", func() {
+			status := DoContext(ctx, func(ctx context.Context) error { return nil }, &UntilNoError{}, bo, activity)
+			So(status.Concurrent, ShouldEqual, 0)
+			So(status.Winner, ShouldEqual, 0)
+		})
+	})
+}
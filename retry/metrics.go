@@ -0,0 +1,59 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"github.com/wtsi-ssg/wr/metrics"
+)
+
+// callsCounter returns the Counter in metrics.Default of Do() calls.
+func callsCounter() *metrics.Counter {
+	return metrics.Default.Counter("wr_retry_calls_total", "Number of Do() calls.", "activity")
+}
+
+// attemptsCounter returns the Counter in metrics.Default of attempts made by
+// Do() calls.
+func attemptsCounter() *metrics.Counter {
+	return metrics.Default.Counter("wr_retry_attempts_total", "Number of attempts made by Do() calls.", "activity")
+}
+
+// gaveUpCounter returns the Counter in metrics.Default of Do() calls that gave
+// up without succeeding.
+func gaveUpCounter() *metrics.Counter {
+	return metrics.Default.Counter("wr_retry_gave_up_total", "Number of Do() calls that gave up without succeeding.",
+		"activity", "reason")
+}
+
+// recordMetrics updates our metrics with the outcome of a Do() call for the
+// given activity.
+func recordMetrics(activity string, status *Status) {
+	callsCounter().Inc(activity)
+	attemptsCounter().Add(float64(len(status.Attempts)), activity)
+
+	if status.gaveUp() {
+		gaveUpCounter().Inc(activity, string(status.StoppedBecause))
+	}
+}
@@ -0,0 +1,187 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Attempt describes an attempt at running an Operation. The history of
+// attempts is recorded in Status, and Attempts are also passed to the hooks you
+// can supply to Do() using the On* Options.
+type Attempt struct {
+	// Activity is the activity that was passed to Do().
+	Activity string
+
+	// Retries is the number of retries that were done before this attempt,
+	// so it is 0 for the first attempt.
+	Retries int
+
+	// Start is when the attempt started, according to the Backoff's Sleeper if
+	// it is a backoff.Clock, or else real time.
+	Start time.Time
+
+	// Duration is how long the attempt took, including any time spent waiting
+	// for AttemptGates. It is not set for OnAttempt hooks, since the attempt
+	// hasn't happened yet.
+	Duration time.Duration
+
+	// Err is the error returned by the attempt. It is not set for OnAttempt
+	// hooks.
+	Err error
+
+	// Sleep is how long we slept for after the attempt, before the next
+	// attempt. It is 0 for the final attempt, and is not set for OnAttempt
+	// hooks.
+	Sleep time.Duration
+}
+
+// attemptJSON is the JSON representation of an Attempt.
+type attemptJSON struct {
+	Retries  int       `json:"retries"`
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration"`
+	Err      string    `json:"err,omitempty"`
+	Sleep    string    `json:"sleep"`
+}
+
+// MarshalJSON implements json.Marshaler, representing durations as strings
+// like "1.5s" and the error by its message.
+func (a Attempt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&attemptJSON{
+		Retries:  a.Retries,
+		Start:    a.Start,
+		Duration: a.Duration.String(),
+		Err:      errorString(a.Err),
+		Sleep:    a.Sleep.String(),
+	})
+}
+
+// AttemptHook is a function that can be called by Do() with details of an
+// Attempt. The context will be the one used for logging by Do().
+type AttemptHook func(ctx context.Context, attempt Attempt)
+
+// StatusHook is a function that can be called by Do() with the final Status.
+// The context will be the one used for logging by Do().
+type StatusHook func(ctx context.Context, status *Status)
+
+// Option configures optional behaviour of Do().
+type Option func(*options)
+
+// options holds the optional behaviour configured by Options.
+type options struct {
+	onAttempt      []AttemptHook
+	onRetry        []AttemptHook
+	onGiveUp       []StatusHook
+	attemptTimeout time.Duration
+	gates          []Gate
+	untils         Untils
+	hedging        *hedging
+}
+
+// newOptions returns the options configured by the given Options.
+func newOptions(opts []Option) *options {
+	o := &options{}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// OnAttempt returns an Option that makes Do() call the given hook just before
+// each attempt at running the Operation.
+func OnAttempt(hook AttemptHook) Option {
+	return func(o *options) {
+		o.onAttempt = append(o.onAttempt, hook)
+	}
+}
+
+// OnRetry returns an Option that makes Do() call the given hook after each
+// failed attempt that will be retried, just before sleeping.
+func OnRetry(hook AttemptHook) Option {
+	return func(o *options) {
+		o.onRetry = append(o.onRetry, hook)
+	}
+}
+
+// OnGiveUp returns an Option that makes Do() call the given hook if it stops
+// retrying while the Operation is still returning an error, or for DoValue(),
+// before a value was accepted.
+func OnGiveUp(hook StatusHook) Option {
+	return func(o *options) {
+		o.onGiveUp = append(o.onGiveUp, hook)
+	}
+}
+
+// Gate is something that attempts must pass through before running, such as a
+// ratelimit.Limiter.
+type Gate interface {
+	// Wait blocks until an attempt may proceed, returning an error if the
+	// context is cancelled first.
+	Wait(ctx context.Context) error
+}
+
+// AttemptGate returns an Option that makes Do() Wait() on the given Gate before
+// each attempt at running the Operation. If Wait() returns an error, the
+// attempt is not made and the error is recorded as the attempt's error.
+func AttemptGate(gate Gate) Option {
+	return func(o *options) {
+		o.gates = append(o.gates, gate)
+	}
+}
+
+// waitForGates calls Wait() on each of our gates, returning the first error.
+func (o *options) waitForGates(ctx context.Context) error {
+	for _, gate := range o.gates {
+		if err := gate.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// callAttemptHooks calls each of the given hooks with the given Attempt.
+func callAttemptHooks(ctx context.Context, hooks []AttemptHook, attempt Attempt) {
+	for _, hook := range hooks {
+		hook(ctx, attempt)
+	}
+}
+
+// callGiveUpHooks calls our OnGiveUp hooks if the given Status says we gave up.
+func (o *options) callGiveUpHooks(ctx context.Context, status *Status) {
+	if !status.gaveUp() {
+		return
+	}
+
+	for _, hook := range o.onGiveUp {
+		hook(ctx, status)
+	}
+}
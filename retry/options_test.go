@@ -0,0 +1,130 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+// withoutTimes returns the given Attempt with its Start and Duration zeroed, for
+// easy comparison.
+func withoutTimes(attempt Attempt) Attempt {
+	attempt.Start = time.Time{}
+	attempt.Duration = 0
+
+	return attempt
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+	activity := "doing foo"
+
+	Convey("Given hooks supplied as Options to Do()", t, func() {
+		bo := &backoff.Backoff{
+			Min:     1 * time.Millisecond,
+			Max:     4 * time.Millisecond,
+			Factor:  2,
+			Jitter:  backoff.JitterNone,
+			Sleeper: &bm.Sleeper{},
+		}
+
+		var (
+			attempts []Attempt
+			retries  []Attempt
+			gaveUp   []*Status
+		)
+
+		opts := []Option{
+			OnAttempt(func(ctx context.Context, attempt Attempt) {
+				attempts = append(attempts, withoutTimes(attempt))
+			}),
+			OnRetry(func(ctx context.Context, attempt Attempt) {
+				retries = append(retries, withoutTimes(attempt))
+			}),
+			OnGiveUp(func(ctx context.Context, status *Status) {
+				gaveUp = append(gaveUp, status)
+			}),
+		}
+
+		op := func() error {
+			return ErrOp
+		}
+
+		Convey("They are called for each attempt, retry and giving up", func() {
+			status := Do(ctx, op, &UntilLimit{Max: 2}, bo, activity, opts...)
+			So(attempts, ShouldResemble, []Attempt{
+				{Activity: activity, Retries: 0},
+				{Activity: activity, Retries: 1},
+				{Activity: activity, Retries: 2},
+			})
+			So(retries, ShouldResemble, []Attempt{
+				{Activity: activity, Retries: 0, Err: ErrOp, Sleep: 1 * time.Millisecond},
+				{Activity: activity, Retries: 1, Err: ErrOp, Sleep: 2 * time.Millisecond},
+			})
+			So(gaveUp, ShouldResemble, []*Status{status})
+		})
+
+		Convey("OnGiveUp is not called when the Operation succeeds", func() {
+			op = func() error {
+				return nil
+			}
+
+			Do(ctx, op, &UntilNoError{}, bo, activity, opts...)
+			So(len(attempts), ShouldEqual, 1)
+			So(retries, ShouldBeEmpty)
+			So(gaveUp, ShouldBeEmpty)
+		})
+
+		Convey("They work with DoValue()", func() {
+			isPositive := func(n int) bool { return n > 0 }
+			DoValue(ctx, func() (int, error) { return 0, ErrOp }, &UntilLimit{Max: 1}, bo, activity, opts...)
+			So(len(attempts), ShouldEqual, 2)
+			So(len(retries), ShouldEqual, 1)
+			So(len(gaveUp), ShouldEqual, 1)
+
+			Convey("Including when no value was accepted, but there was no error", func() {
+				gaveUpCount := gaveUpCounter().Value(activity, string(BecauseLimitReached))
+				until := Untils{&UntilValue[int]{Accept: isPositive}, &UntilLimit{Max: 1}}
+				_, status := DoValue(ctx, func() (int, error) { return 0, nil }, until, bo, activity, opts...)
+				So(status.Err, ShouldBeNil)
+				So(gaveUp, ShouldResemble, []*Status{gaveUp[0], status})
+				So(gaveUpCounter().Value(activity, string(BecauseLimitReached)), ShouldEqual, gaveUpCount+1)
+			})
+
+			Convey("But not when a value was accepted", func() {
+				DoValue(ctx, func() (int, error) { return 1, ErrOp }, &UntilValue[int]{Accept: isPositive}, bo,
+					activity, opts...)
+				So(len(gaveUp), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,429 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wtsi-ssg/wr/backoff"
+	btime "github.com/wtsi-ssg/wr/backoff/time"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPolicyMin etc. are the backoff settings used by Policies that don't
+// specify them.
+const (
+	defaultPolicyMin    = 250 * time.Millisecond
+	defaultPolicyMax    = 3 * time.Second
+	defaultPolicyFactor = 1.5
+)
+
+// fallbackPolicyMaxRetries is the MaxRetries of the Policy used for activities
+// when there is no policy for them and no default policy.
+const fallbackPolicyMaxRetries = 6
+
+// Duration is a time.Duration that can be unmarshalled from YAML or JSON
+// strings like "1.5s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+// parse sets our value from a string like "1.5s".
+func (d *Duration) parse(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// BackoffPolicy holds the settings of a backoff.Backoff.
+type BackoffPolicy struct {
+	Min    Duration           `json:"min" yaml:"min"`
+	Max    Duration           `json:"max" yaml:"max"`
+	Factor float64            `json:"factor" yaml:"factor"`
+	Jitter backoff.JitterMode `json:"jitter" yaml:"jitter"`
+}
+
+// Policy describes how to retry an activity. It can be unmarshalled from YAML
+// or JSON, and is normally loaded from a file by LoadPolicies().
+type Policy struct {
+	// Backoff configures the backoff.Backoff used between retries. Unset
+	// values take generally useful defaults.
+	Backoff BackoffPolicy `json:"backoff" yaml:"backoff"`
+
+	// MaxRetries configures an UntilLimit. If unset, the number of retries is
+	// not limited.
+	MaxRetries *int `json:"maxRetries" yaml:"maxRetries"`
+
+	// TimeBudget configures an UntilElapsed, if set.
+	TimeBudget Duration `json:"timeBudget" yaml:"timeBudget"`
+
+	// AttemptTimeout configures the AttemptTimeout() Option, if set.
+	AttemptTimeout Duration `json:"attemptTimeout" yaml:"attemptTimeout"`
+
+	// PermanentErrors names errors registered with Policies.RegisterError()
+	// that should stop retries, via an UntilErrorMatches.
+	PermanentErrors []string `json:"permanentErrors" yaml:"permanentErrors"`
+}
+
+// NewBackoff returns a backoff.Backoff configured by this Policy, that uses
+// the given Sleeper.
+func (p *Policy) NewBackoff(sleeper backoff.Sleeper) *backoff.Backoff {
+	bo := &backoff.Backoff{
+		Min:     time.Duration(p.Backoff.Min),
+		Max:     time.Duration(p.Backoff.Max),
+		Factor:  p.Backoff.Factor,
+		Jitter:  p.Backoff.Jitter,
+		Sleeper: sleeper,
+	}
+
+	if bo.Min == 0 {
+		bo.Min = defaultPolicyMin
+	}
+
+	if bo.Max == 0 {
+		bo.Max = defaultPolicyMax
+	}
+
+	if bo.Factor == 0 {
+		bo.Factor = defaultPolicyFactor
+	}
+
+	return bo
+}
+
+// Until returns an Until configured by this Policy, which will always stop
+// when there is no error. errs should contain every error named in
+// PermanentErrors.
+func (p *Policy) Until(errs map[string]error) Until {
+	untils := Untils{&UntilNoError{}}
+
+	if p.MaxRetries != nil {
+		untils = append(untils, &UntilLimit{Max: *p.MaxRetries})
+	}
+
+	if p.TimeBudget > 0 {
+		untils = append(untils, &UntilElapsed{Max: time.Duration(p.TimeBudget)})
+	}
+
+	if len(p.PermanentErrors) > 0 {
+		targets := make([]error, len(p.PermanentErrors))
+		for i, name := range p.PermanentErrors {
+			targets[i] = errs[name]
+		}
+
+		untils = append(untils, &UntilErrorMatches{Matcher: ErrorIs(targets...)})
+	}
+
+	return untils
+}
+
+// Options returns the Options configured by this Policy.
+func (p *Policy) Options() []Option {
+	if p.AttemptTimeout <= 0 {
+		return nil
+	}
+
+	return []Option{AttemptTimeout(time.Duration(p.AttemptTimeout))}
+}
+
+// policyFile is the format of a file loaded by LoadPolicies().
+type policyFile struct {
+	Default    *Policy            `json:"default" yaml:"default"`
+	Activities map[string]*Policy `json:"activities" yaml:"activities"`
+}
+
+// ErrUnknownPermanentError is returned when a policy file names a permanent
+// error that has not been registered.
+var ErrUnknownPermanentError = errors.New("unknown permanent error")
+
+// ErrUnknownJitter is returned when a policy file names a backoff jitter mode
+// that isn't one of the backoff.Jitter* constants.
+var ErrUnknownJitter = errors.New("unknown jitter mode")
+
+// Policies is a registry of Policy keyed on the activity names passed to Do(),
+// loaded from a YAML or JSON file like:
+//
+//	default:
+//	  backoff: {min: 250ms, max: 3s, factor: 1.5}
+//	  maxRetries: 6
+//	activities:
+//	  getting volume usage:
+//	    backoff: {min: 1s, max: 10s, factor: 2, jitter: full}
+//	    timeBudget: 1m
+//	    permanentErrors: [notexist]
+//
+// It is concurrent safe.
+type Policies struct {
+	// Sleeper is used by the Backoffs created by Do(). If nil, real time is
+	// used.
+	Sleeper backoff.Sleeper
+
+	path          string
+	modTime       time.Time
+	failed        bool
+	failedModTime time.Time
+	file          *policyFile
+	errs          map[string]error
+	mu            sync.RWMutex
+	reloadMu      sync.Mutex
+}
+
+// LoadPolicies loads the Policies in the YAML or JSON file at the given path.
+// errs gives the names that policies can use in PermanentErrors.
+//
+// If the file can't be loaded, an error is returned along with Policies that
+// are still usable: they give every activity the fallback Policy described in
+// For() until a Reload() succeeds, eg. once the file appears while you Watch()
+// it.
+func LoadPolicies(path string, errs map[string]error) (*Policies, error) {
+	p := &Policies{path: path, errs: errs, file: &policyFile{}}
+
+	return p, p.Reload()
+}
+
+// Reload re-reads our file, replacing our Policies. If the file can't be read
+// or is invalid, the existing Policies are kept and an error is returned.
+func (p *Policies) Reload() error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := p.parse()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.file = file
+	p.modTime = info.ModTime()
+
+	return nil
+}
+
+// parse reads and validates our file.
+func (p *Policies) parse() (*policyFile, error) {
+	content, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &policyFile{}
+	if err = yaml.Unmarshal(content, file); err != nil {
+		return nil, err
+	}
+
+	return file, p.validate(file)
+}
+
+// validate checks all the policies in the given file with validatePolicy().
+func (p *Policies) validate(file *policyFile) error {
+	if err := p.validatePolicy(file.Default); err != nil {
+		return err
+	}
+
+	for _, policy := range file.Activities {
+		if err := p.validatePolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePolicy checks that the given Policy, if not nil, has a known jitter
+// mode, and that all its PermanentErrors have been registered.
+func (p *Policies) validatePolicy(policy *Policy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if !knownJitter(policy.Backoff.Jitter) {
+		return fmt.Errorf("%w: %s", ErrUnknownJitter, policy.Backoff.Jitter)
+	}
+
+	for _, name := range policy.PermanentErrors {
+		if _, ok := p.errs[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownPermanentError, name)
+		}
+	}
+
+	return nil
+}
+
+// knownJitter returns true if the given mode is one of the backoff.Jitter*
+// constants.
+func knownJitter(mode backoff.JitterMode) bool {
+	switch mode {
+	case backoff.JitterDefault, backoff.JitterNone, backoff.JitterFull, backoff.JitterEqual,
+		backoff.JitterDecorrelated:
+		return true
+	default:
+		return false
+	}
+}
+
+// For returns the Policy for the given activity, or the default Policy if
+// there isn't one specific to the activity. If there is no default either,
+// returns a Policy that only sets MaxRetries, to 6, so that retries are never
+// endless by accident.
+func (p *Policies) For(activity string) *Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if policy, ok := p.file.Activities[activity]; ok && policy != nil {
+		return policy
+	}
+
+	if p.file.Default != nil {
+		return p.file.Default
+	}
+
+	maxRetries := fallbackPolicyMaxRetries
+
+	return &Policy{MaxRetries: &maxRetries}
+}
+
+// Do calls Do() with an Until, Backoff and Options created from the Policy
+// For() the given activity. Any opts you supply are applied after those from
+// the Policy.
+func (p *Policies) Do(ctx context.Context, op Operation, activity string, opts ...Option) *Status {
+	policy := p.For(activity)
+
+	return Do(ctx, op, policy.Until(p.errs), policy.NewBackoff(p.sleeper()), activity,
+		append(policy.Options(), opts...)...)
+}
+
+// sleeper returns our Sleeper, defaulting to real time.
+func (p *Policies) sleeper() backoff.Sleeper {
+	if p.Sleeper == nil {
+		return &btime.Sleeper{}
+	}
+
+	return p.Sleeper
+}
+
+// Watch checks our file for changes every interval, and Reload()s it when it
+// has been modified, until the context is cancelled. Reloads are logged using
+// the "retry" clog subsystem at info level, and failures to reload at warn
+// level. You would normally call this in a goroutine.
+func (p *Policies) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.reloadIfModified(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reloadIfModified calls Reload() if our file's modification time has changed
+// since we last loaded it or last failed to, logging the outcome. A file that
+// can't be read is treated as having the zero modification time.
+func (p *Policies) reloadIfModified(ctx context.Context) {
+	var modTime time.Time
+
+	info, err := os.Stat(p.path)
+	if err == nil {
+		modTime = info.ModTime()
+	}
+
+	if !p.modifiedSince(modTime) {
+		return
+	}
+
+	if err == nil {
+		err = p.Reload()
+	}
+
+	p.recordReload(modTime, err)
+
+	if err != nil {
+		logger.Warn(ctx, "retry policies reload failed", "path", p.path, "err", err)
+
+		return
+	}
+
+	logger.Info(ctx, "retry policies reloaded", "path", p.path)
+}
+
+// modifiedSince returns true if the given modification time of our file is
+// different to when we last loaded it, and to when we last failed to.
+func (p *Policies) modifiedSince(modTime time.Time) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.failed && p.failedModTime.Equal(modTime) {
+		return false
+	}
+
+	return !p.modTime.Equal(modTime)
+}
+
+// recordReload remembers the given modification time of our file if the given
+// error from reloading it is not nil, so that we don't keep retrying a broken
+// file until it is modified again.
+func (p *Policies) recordReload(modTime time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failed = err != nil
+	p.failedModTime = modTime
+}
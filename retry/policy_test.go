@@ -0,0 +1,246 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+	"github.com/wtsi-ssg/wr/clog"
+)
+
+const testPolicyYAML = `
+default:
+  backoff: {min: 1ms, max: 4ms, factor: 2, jitter: none}
+  maxRetries: 2
+activities:
+  getting volume usage:
+    backoff: {min: 10ms, max: 1s, factor: 3, jitter: none}
+    maxRetries: 5
+    timeBudget: 1m
+    attemptTimeout: 30s
+    permanentErrors: [permanent]
+`
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	errPermanent := errors.New("permanent")
+	errs := map[string]error{"permanent": errPermanent}
+
+	Convey("Given a policy file", t, func() {
+		path := filepath.Join(t.TempDir(), "policies.yml")
+		err := ioutil.WriteFile(path, []byte(testPolicyYAML), 0600)
+		So(err, ShouldBeNil)
+
+		Convey("You can load Policies from it", func() {
+			p, err := LoadPolicies(path, errs)
+			So(err, ShouldBeNil)
+
+			policy := p.For("getting volume usage")
+			So(time.Duration(policy.Backoff.Min), ShouldEqual, 10*time.Millisecond)
+			So(time.Duration(policy.Backoff.Max), ShouldEqual, 1*time.Second)
+			So(policy.Backoff.Factor, ShouldEqual, 3)
+			So(policy.Backoff.Jitter, ShouldEqual, backoff.JitterNone)
+			So(*policy.MaxRetries, ShouldEqual, 5)
+			So(time.Duration(policy.TimeBudget), ShouldEqual, 1*time.Minute)
+			So(time.Duration(policy.AttemptTimeout), ShouldEqual, 30*time.Second)
+			So(policy.PermanentErrors, ShouldResemble, []string{"permanent"})
+			So(len(policy.Options()), ShouldEqual, 1)
+
+			Convey("Unknown activities get the default", func() {
+				policy = p.For("other")
+				So(time.Duration(policy.Backoff.Min), ShouldEqual, 1*time.Millisecond)
+				So(*policy.MaxRetries, ShouldEqual, 2)
+				So(policy.Options(), ShouldBeNil)
+			})
+
+			Convey("Do() uses the policy for the activity", func() {
+				sleeper := &bm.Sleeper{}
+				p.Sleeper = sleeper
+				op := func() error { return ErrNormal }
+
+				status := p.Do(ctx, op, "other")
+				So(status.Retried, ShouldEqual, 2)
+				So(status.StoppedBecause, ShouldEqual, BecauseLimitReached)
+				So(sleeper.Elapsed(), ShouldEqual, 3*time.Millisecond)
+
+				calls := 0
+				status = p.Do(ctx, func() error {
+					calls++
+					if calls == 2 {
+						return errPermanent
+					}
+
+					return ErrNormal
+				}, "getting volume usage")
+				So(status.Retried, ShouldEqual, 1)
+				So(status.StoppedBecause, ShouldEqual, BecauseErrorMatched)
+
+				status = p.Do(ctx, func() error { return nil }, "getting volume usage")
+				So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+			})
+
+			Convey("Reload() picks up changes", func() {
+				err = ioutil.WriteFile(path, []byte(`{"default": {"maxRetries": 0}}`), 0600)
+				So(err, ShouldBeNil)
+				So(p.Reload(), ShouldBeNil)
+
+				policy = p.For("getting volume usage")
+				So(*policy.MaxRetries, ShouldEqual, 0)
+				So(policy.NewBackoff(nil).Min, ShouldEqual, defaultPolicyMin)
+			})
+
+			Convey("Invalid changes are not applied", func() {
+				err = ioutil.WriteFile(path, []byte(`default: {maxRetries: [`), 0600)
+				So(err, ShouldBeNil)
+				So(p.Reload(), ShouldNotBeNil)
+				So(*p.For("other").MaxRetries, ShouldEqual, 2)
+			})
+
+			Convey("Watch() reloads when the file is modified", func() {
+				buff := clog.ToBufferAtLevel("info")
+				defer clog.ToDefault()
+
+				wctx, cancel := context.WithCancel(ctx)
+				done := make(chan bool)
+
+				go func() {
+					p.Watch(wctx, 1*time.Millisecond)
+					close(done)
+				}()
+
+				err = ioutil.WriteFile(path, []byte(`default: {maxRetries: 7}`), 0600)
+				So(err, ShouldBeNil)
+				future := time.Now().Add(1 * time.Hour)
+				So(os.Chtimes(path, future, future), ShouldBeNil)
+
+				reloaded := false
+				for i := 0; i < 1000 && !reloaded; i++ {
+					reloaded = *p.For("other").MaxRetries == 7
+					time.Sleep(1 * time.Millisecond)
+				}
+
+				cancel()
+				<-done
+
+				So(reloaded, ShouldBeTrue)
+				So(buff.String(), ShouldContainSubstring, "retry policies reloaded")
+			})
+
+			Convey("Watch() only tries to reload an invalid file once", func() {
+				buff := clog.ToBufferAtLevel("info")
+				defer clog.ToDefault()
+
+				wctx, cancel := context.WithCancel(ctx)
+				done := make(chan bool)
+
+				go func() {
+					p.Watch(wctx, 1*time.Millisecond)
+					close(done)
+				}()
+
+				err = ioutil.WriteFile(path, []byte(`default: {maxRetries: [`), 0600)
+				So(err, ShouldBeNil)
+				future := time.Now().Add(1 * time.Hour)
+				So(os.Chtimes(path, future, future), ShouldBeNil)
+
+				<-time.After(20 * time.Millisecond)
+				cancel()
+				<-done
+
+				So(strings.Count(buff.String(), "retry policies reload failed"), ShouldEqual, 1)
+				So(*p.For("other").MaxRetries, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Activities get a limited number of retries without a default", func() {
+			err = ioutil.WriteFile(path, []byte(`activities: {foo: {maxRetries: 1}}`), 0600)
+			So(err, ShouldBeNil)
+
+			p, err := LoadPolicies(path, errs)
+			So(err, ShouldBeNil)
+			So(*p.For("foo").MaxRetries, ShouldEqual, 1)
+			So(*p.For("other").MaxRetries, ShouldEqual, fallbackPolicyMaxRetries)
+		})
+
+		Convey("Loading fails if it names an unknown jitter mode", func() {
+			err = ioutil.WriteFile(path, []byte(`default: {backoff: {jitter: ful}}`), 0600)
+			So(err, ShouldBeNil)
+
+			_, err := LoadPolicies(path, errs)
+			So(errors.Is(err, ErrUnknownJitter), ShouldBeTrue)
+		})
+
+		Convey("Loading fails if it names unregistered errors", func() {
+			_, err := LoadPolicies(path, nil)
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrUnknownPermanentError), ShouldBeTrue)
+		})
+	})
+
+	Convey("Loading a non-existent file fails", t, func() {
+		path := filepath.Join(t.TempDir(), "missing.yml")
+		p, err := LoadPolicies(path, errs)
+		So(err, ShouldNotBeNil)
+
+		Convey("But the Policies give the fallback policy until the file appears", func() {
+			So(*p.For("foo").MaxRetries, ShouldEqual, fallbackPolicyMaxRetries)
+			status := p.Do(ctx, func() error { return nil }, "foo")
+			So(status.Err, ShouldBeNil)
+
+			wctx, cancel := context.WithCancel(ctx)
+			done := make(chan bool)
+
+			go func() {
+				p.Watch(wctx, 1*time.Millisecond)
+				close(done)
+			}()
+
+			err = ioutil.WriteFile(path, []byte(`default: {maxRetries: 7}`), 0600)
+			So(err, ShouldBeNil)
+
+			reloaded := false
+			for i := 0; i < 1000 && !reloaded; i++ {
+				reloaded = *p.For("foo").MaxRetries == 7
+				time.Sleep(1 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			So(reloaded, ShouldBeTrue)
+		})
+	})
+}
@@ -28,17 +28,32 @@ package retry
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/wtsi-ssg/wr/backoff"
 	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/tracing"
 )
 
+// logger is used for all our logging, as the "retry" subsystem.
+const logger clog.Logger = "retry"
+
 // Operation is passed to Do() and is the code you would like to retry.
 type Operation func() error
 
+// ContextOperation is passed to DoContext() and is the code you would like to
+// retry, when that code should stop if the given context is cancelled.
+type ContextOperation func(ctx context.Context) error
+
+// valueOperation is what a retrier actually runs; all the other types of
+// operation are converted to this.
+type valueOperation func(ctx context.Context) (interface{}, error)
+
 // Status is returned by Do() to explain what happened when retrying your
-// Operation. It can be stringified or used as an error that wraps Err.
+// Operation. It can be stringified, marshalled to JSON, or used as an error
+// that wraps Err and the errors of all the Attempts.
 type Status struct {
 	// Retried is the number of retries done (which can be 0 if the Operation
 	// only needed to be run once).
@@ -49,6 +64,19 @@ type Status struct {
 
 	// Err is the last return value of the Operation.
 	Err error
+
+	// Attempts is the history of every attempt at running the Operation, in
+	// order.
+	Attempts []Attempt
+
+	// Concurrent is the number of concurrent attempts that were started during
+	// the final attempt, when the Hedge() Option was used. It is 0 otherwise.
+	Concurrent int
+
+	// Winner is the number (starting from 1) of the concurrent attempt that
+	// succeeded during the final attempt, when the Hedge() Option was used. It
+	// is 0 if none succeeded, or Hedge() wasn't used.
+	Winner int
 }
 
 // String returns a string representation of the Status.
@@ -66,65 +94,242 @@ func (s *Status) Error() string {
 	return s.String()
 }
 
-// Unwrap implements the error interface, returning our wrapped error.
-func (s *Status) Unwrap() error {
-	return s.Err
+// Unwrap implements the error interface, returning the errors of our Attempts,
+// most recent (ie. Err) first, so that errors.Is() and errors.As() will match
+// against the error of any attempt. If there were no Attempts, it returns Err.
+func (s *Status) Unwrap() []error {
+	if len(s.Attempts) == 0 {
+		return appendIfNotNil(nil, s.Err)
+	}
+
+	errs := make([]error, 0, len(s.Attempts))
+
+	for i := len(s.Attempts) - 1; i >= 0; i-- {
+		errs = appendIfNotNil(errs, s.Attempts[i].Err)
+	}
+
+	return errs
+}
+
+// appendIfNotNil appends err to errs if it isn't nil.
+func appendIfNotNil(errs []error, err error) []error {
+	if err == nil {
+		return errs
+	}
+
+	return append(errs, err)
+}
+
+// gaveUp returns true if we stopped trying without the Operation succeeding, ie.
+// for any reason other than there being no error or a value being accepted.
+func (s *Status) gaveUp() bool {
+	return s.StoppedBecause != BecauseErrorNil && s.StoppedBecause != BecauseValueAccepted
+}
+
+// statusJSON is the JSON representation of a Status.
+type statusJSON struct {
+	Retried        int       `json:"retried"`
+	StoppedBecause Reason    `json:"stoppedBecause"`
+	Err            string    `json:"err,omitempty"`
+	Attempts       []Attempt `json:"attempts"`
+	Concurrent     int       `json:"concurrent,omitempty"`
+	Winner         int       `json:"winner,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, representing errors by their
+// messages.
+func (s *Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&statusJSON{
+		Retried:        s.Retried,
+		StoppedBecause: s.StoppedBecause,
+		Err:            errorString(s.Err),
+		Attempts:       s.Attempts,
+		Concurrent:     s.Concurrent,
+		Winner:         s.Winner,
+	})
+}
+
+// errorString returns err.Error(), or blank if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
 }
 
 // Do will run op at least once, and then will keep retrying it unless the until
-// returns a Reason to stop, or the context has been cancelled. The amount of
-// time between retries is determined by bo.
+// returns a Reason to stop, the context has been cancelled, or op returned an
+// error wrapped with Permanent(). The amount of time between retries is
+// determined by bo.
 //
 // The context is also used to end bo's sleep early, if cancelled during a
-// sleep.
+// sleep. bo will not sleep past the context's deadline, nor past the time
+// budget of any UntilElapsed in until.
 //
-// If any retries were required, the returned Status is logged using the global
-// context logger at debug level. Any Backoff sleeps will have been logged
+// If any retries were required, the returned Status is logged using the "retry"
+// clog subsystem at debug level. Any Backoff sleeps will have been logged
 // sharing a unique retryset id, and a retrynum. All logs will include the given
 // activity.
 //
+// The call is counted in metrics.Default, including whether it gave up with an
+// error, labelled with the activity.
+//
+// If tracing is enabled, the call is traced as a Span named after the activity,
+// with the retryset id as an attribute, a child Span for each attempt, and an
+// event for each Backoff sleep. The context passed to ContextOperations knows
+// about the attempt Span.
+//
+// You can supply Options such as OnRetry() to observe the individual attempts.
+//
 // Note that bo is NOT Reset() during this function.
-func Do(ctx context.Context, op Operation, until Until, bo *backoff.Backoff, activity string) *Status {
-	var (
-		reason  Reason
-		retries int
-		err     error
-	)
+func Do(ctx context.Context, op Operation, until Until, bo *backoff.Backoff, activity string,
+	opts ...Option) *Status {
+	return newRetrier(ctx, func(context.Context) (interface{}, error) {
+		return nil, op()
+	}, until, bo, activity, opts).do()
+}
+
+// DoContext is like Do(), but for ContextOperations. Each attempt is given a
+// context derived from ctx, which will also have a deadline if you supply the
+// AttemptTimeout() Option.
+func DoContext(ctx context.Context, op ContextOperation, until Until, bo *backoff.Backoff, activity string,
+	opts ...Option) *Status {
+	return newRetrier(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, op(ctx)
+	}, until, bo, activity, opts).do()
+}
+
+// retrier holds the state of a Do() call.
+type retrier struct {
+	ctx      context.Context
+	op       valueOperation
+	untils   Untils
+	bo       *backoff.Backoff
+	activity string
+	opts     *options
+	retries  int
+	reason   Reason
+	value    interface{}
+	err      error
+	attempts []Attempt
+	hedges   int
+	winner   int
+}
+
+// newRetrier returns a retrier that will run the given op.
+func newRetrier(ctx context.Context, op valueOperation, until Until, bo *backoff.Backoff, activity string,
+	opts []Option) *retrier {
+	o := newOptions(opts)
 
-	until = Untils{until, &untilContext{Context: ctx}}
+	return &retrier{
+		ctx:      clog.ContextForRetries(ctx, activity),
+		op:       op,
+		untils:   Untils{until, &untilContext{Context: ctx}, &untilPermanentError{}, o.untils},
+		bo:       bo,
+		activity: activity,
+		opts:     o,
+	}
+}
 
-	ctx = clog.ContextForRetries(ctx, activity)
+// do runs our op until our untils say to stop, sleeping in between attempts,
+// and returns the resulting Status.
+func (r *retrier) do() *Status {
+	var span *tracing.Span
 
-	for ok := true; ok; ok = tryAgain(ctx, bo, reason, &retries) {
-		err = op()
-		reason = until.ShouldStop(retries, err)
+	r.ctx, span = tracing.Start(r.ctx, r.activity, "retryset", clog.RetrySet(r.ctx))
+	r.untils.startBudget()
+
+	for ok := true; ok; ok = r.tryAgain() {
+		r.attempt()
 	}
 
-	status := &Status{Retried: retries, StoppedBecause: reason, Err: err}
-	logStatusIfRetried(ctx, status)
+	status := &Status{
+		Retried:        r.retries,
+		StoppedBecause: r.reason,
+		Err:            r.err,
+		Attempts:       r.attempts,
+		Concurrent:     r.hedges,
+		Winner:         r.winner,
+	}
+	logStatusIfRetried(r.ctx, status)
+	r.opts.callGiveUpHooks(r.ctx, status)
+	endSpan(span, status)
+	recordMetrics(r.activity, status)
 
 	return status
 }
 
-// tryAgain tests reason to see if we should try again, and if so, increments
-// retries and uses the backoff to sleep before returning.
-func tryAgain(ctx context.Context, bo *backoff.Backoff, reason Reason, retries *int) bool {
-	if reason != doNotStop {
+// endSpan records the outcome in the given Status on the given Span and ends
+// it.
+func endSpan(span *tracing.Span, status *Status) {
+	span.SetAttributes("retried", status.Retried, "stoppedBecause", string(status.StoppedBecause))
+	span.SetError(status.Err)
+	span.End()
+}
+
+// attempt waits for any gates, runs our op once and checks if we should stop.
+// The attempt is traced as a child Span of the Do() Span.
+func (r *retrier) attempt() {
+	attempt := Attempt{Activity: r.activity, Retries: r.retries, Start: r.now()}
+	callAttemptHooks(r.ctx, r.opts.onAttempt, attempt)
+
+	ctx, span := tracing.Start(r.ctx, "attempt", "retries", r.retries)
+
+	r.value, r.err = nil, r.opts.waitForGates(ctx)
+	if r.err == nil {
+		r.value, r.err = r.runOp(ctx)
+	}
+
+	span.SetError(r.err)
+	span.End()
+
+	attempt.Duration = r.now().Sub(attempt.Start)
+	attempt.Err = r.err
+	r.attempts = append(r.attempts, attempt)
+
+	r.untils.observeValue(r.value)
+	r.untils.observeAttempt(r.err)
+	r.reason = r.untils.ShouldStop(r.retries, r.err)
+}
+
+// tryAgain tests our reason to see if we should try again, and if so,
+// increments retries and uses the backoff to sleep (but not past any time
+// budget deadline) before returning.
+func (r *retrier) tryAgain() bool {
+	if r.reason != doNotStop {
 		return false
 	}
 
-	*retries++
+	ctx := clog.ContextWithRetryNum(r.ctx, r.retries+1)
+	d := r.bo.Next(ctx, r.untils.budgetDeadline())
 
-	bo.Sleep(clog.ContextWithRetryNum(ctx, *retries))
+	attempt := &r.attempts[len(r.attempts)-1]
+	attempt.Sleep = d
+
+	callAttemptHooks(ctx, r.opts.onRetry, *attempt)
+
+	r.retries++
+	r.bo.SleepFor(ctx, d)
 
 	return true
 }
 
+// now returns the current time according to our backoff's Sleeper if it is a
+// backoff.Clock, or else real time.
+func (r *retrier) now() time.Time {
+	if clock, ok := r.bo.Sleeper.(backoff.Clock); ok {
+		return clock.Now()
+	}
+
+	return time.Now()
+}
+
 // logStatusIfRetried logs the status if status.Retried > 0.
 func logStatusIfRetried(ctx context.Context, status *Status) {
 	if status.Retried == 0 {
 		return
 	}
 
-	clog.Debug(ctx, "retried", "status", status.String())
+	logger.Debug(ctx, "retried", "status", status.String())
 }
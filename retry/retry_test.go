@@ -27,7 +27,9 @@ package retry
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -35,6 +37,7 @@ import (
 	"github.com/wtsi-ssg/wr/backoff"
 	bm "github.com/wtsi-ssg/wr/backoff/mock"
 	"github.com/wtsi-ssg/wr/clog"
+	"github.com/wtsi-ssg/wr/tracing"
 )
 
 var ErrOp = errors.New("op err")
@@ -68,7 +71,7 @@ func TestRetry(t *testing.T) {
 		msg := "after 2 retries, stopped trying because there was no error"
 		So(status.String(), ShouldEqual, msg)
 		So(status.Error(), ShouldEqual, msg)
-		So(status.Unwrap(), ShouldBeNil)
+		So(status.Unwrap(), ShouldResemble, []error{ErrOp, ErrOp})
 		So(count, ShouldEqual, 3)
 		So(sleeper.Elapsed(), ShouldEqual, 2*time.Millisecond)
 
@@ -104,7 +107,7 @@ func TestRetry(t *testing.T) {
 		msg := "after 2 retries, stopped trying because limit reached; err: op err"
 		So(status.String(), ShouldEqual, msg)
 		So(status.Error(), ShouldEqual, msg)
-		So(status.Unwrap(), ShouldEqual, ErrOp)
+		So(status.Unwrap(), ShouldResemble, []error{ErrOp, ErrOp, ErrOp})
 		So(count, ShouldEqual, 3)
 		So(sleeper.Elapsed(), ShouldEqual, 2*time.Millisecond)
 	})
@@ -128,4 +131,258 @@ func TestRetry(t *testing.T) {
 
 		So(buff.String(), ShouldBeBlank)
 	})
+
+	Convey("Retries blocked in a backoff sleep stop when the context is cancelled", t, func() {
+		clock := bm.NewClock(time.Now())
+		backoff.Sleeper = clock
+		cctx, cancel := context.WithCancel(ctx)
+		op := func() error {
+			return ErrOp
+		}
+
+		statusCh := make(chan *Status)
+
+		go func() {
+			statusCh <- Do(cctx, op, &UntilNoError{}, backoff, activity)
+		}()
+
+		So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+		clock.Advance(wait)
+		So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+		cancel()
+
+		status := <-statusCh
+		So(status.Retried, ShouldEqual, 2)
+		So(status.StoppedBecause, ShouldEqual, BecauseContextClosed)
+		So(status.Err, ShouldEqual, ErrOp)
+	})
+
+	Convey("You can Retry things until a time budget is used up, without sleeping past it", t, func() {
+		clock := bm.NewClock(time.Now())
+		backoff.Sleeper = clock
+		buff := clog.ToBufferAtLevel("debug")
+		defer clog.ToDefault()
+
+		op := func() error {
+			return ErrOp
+		}
+
+		statusCh := make(chan *Status)
+
+		go func() {
+			statusCh <- Do(ctx, op, &UntilElapsed{Max: 5 * wait / 2, Clock: clock}, backoff, activity)
+		}()
+
+		for i := 0; i < 3; i++ {
+			So(clock.WaitForSleepers(ctx, 1), ShouldBeTrue)
+			clock.Advance(wait)
+		}
+
+		status := <-statusCh
+		So(status.Retried, ShouldEqual, 3)
+		So(status.StoppedBecause, ShouldEqual, BecauseTimeBudgetExceeded)
+		So(status.Err, ShouldEqual, ErrOp)
+		So(buff.String(), ShouldContainSubstring, "sleep=500µs")
+	})
+
+	Convey("Retries stop when the Operation returns a Permanent error", t, func() {
+		count := 0
+		op := func() error {
+			count++
+			if count == 2 {
+				return Permanent(ErrOp)
+			}
+
+			return ErrOp
+		}
+
+		backoff.Sleeper = &bm.Sleeper{}
+
+		status := Do(ctx, op, &UntilLimit{Max: 5}, backoff, activity)
+		So(status.Retried, ShouldEqual, 1)
+		So(status.StoppedBecause, ShouldEqual, BecausePermanentError)
+		So(errors.Is(status, ErrOp), ShouldBeTrue)
+		So(count, ShouldEqual, 2)
+	})
+}
+
+// advancingClock is a backoff/mock.Clock that advances itself when slept on.
+type advancingClock struct {
+	*bm.Clock
+}
+
+func (c advancingClock) Sleep(ctx context.Context, d time.Duration) {
+	c.Advance(d)
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	activity := "doing foo"
+
+	Convey("Status records the history of attempts", t, func() {
+		start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		clock := advancingClock{bm.NewClock(start)}
+		bo := &backoff.Backoff{
+			Min:     1 * time.Millisecond,
+			Max:     10 * time.Millisecond,
+			Factor:  2,
+			Jitter:  backoff.JitterNone,
+			Sleeper: clock,
+		}
+		errA, errB := errors.New("a"), errors.New("b")
+		errs := []error{errA, errB, nil}
+		count := 0
+		op := func() error {
+			clock.Advance(5 * time.Millisecond)
+			count++
+
+			return errs[count-1]
+		}
+
+		status := Do(ctx, op, &UntilNoError{}, bo, activity)
+		So(status.Retried, ShouldEqual, 2)
+		So(status.Attempts, ShouldResemble, []Attempt{
+			{Activity: activity, Retries: 0, Start: start, Duration: 5 * time.Millisecond, Err: errA,
+				Sleep: 1 * time.Millisecond},
+			{Activity: activity, Retries: 1, Start: start.Add(6 * time.Millisecond), Duration: 5 * time.Millisecond,
+				Err: errB, Sleep: 2 * time.Millisecond},
+			{Activity: activity, Retries: 2, Start: start.Add(13 * time.Millisecond), Duration: 5 * time.Millisecond},
+		})
+
+		Convey("Which can be matched with errors.Is()", func() {
+			So(errors.Is(status, errA), ShouldBeTrue)
+			So(errors.Is(status, errB), ShouldBeTrue)
+			So(errors.Is(status, ErrOp), ShouldBeFalse)
+		})
+
+		Convey("Even when the errors can't be compared with ==", func() {
+			op = func() error { return multiError{errA, context.Canceled} }
+			status = Do(ctx, op, &UntilLimit{Max: 1}, bo, activity)
+			So(status.Unwrap(), ShouldHaveLength, 2)
+			So(func() { errors.Is(status, context.Canceled) }, ShouldNotPanic)
+			So(errors.As(status, &multiError{}), ShouldBeTrue)
+		})
+
+		Convey("And it can be marshalled to JSON", func() {
+			j, err := json.Marshal(status)
+			So(err, ShouldBeNil)
+			So(string(j), ShouldEqual, `{"retried":2,"stoppedBecause":"there was no error","attempts":[`+
+				`{"retries":0,"start":"2020-10-01T12:00:00Z","duration":"5ms","err":"a","sleep":"1ms"},`+
+				`{"retries":1,"start":"2020-10-01T12:00:00.006Z","duration":"5ms","err":"b","sleep":"2ms"},`+
+				`{"retries":2,"start":"2020-10-01T12:00:00.013Z","duration":"5ms","sleep":"0s"}]}`)
+		})
+	})
+}
+
+// multiError is an error that can't be compared with ==.
+type multiError []error
+
+func (m multiError) Error() string {
+	return fmt.Sprintf("%d errors", len(m))
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	activity := "doing foo"
+
+	Convey("With tracing enabled, Do() creates Spans", t, func() {
+		exporter := &tracing.InMemoryExporter{}
+		tracing.SetExporter(exporter)
+		defer tracing.SetExporter(nil)
+
+		buff := clog.ToBufferAtLevel("debug")
+		defer clog.ToDefault()
+
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		count := 0
+		var opSpans []*tracing.Span
+		op := func(ctx context.Context) error {
+			opSpans = append(opSpans, tracing.SpanFromContext(ctx))
+			count++
+			if count == 2 {
+				return nil
+			}
+
+			return ErrOp
+		}
+
+		status := DoContext(ctx, op, &UntilNoError{}, bo, activity)
+		So(status.Err, ShouldBeNil)
+
+		spans := exporter.Spans()
+		So(len(spans), ShouldEqual, 3)
+
+		first, second, do := spans[0], spans[1], spans[2]
+		So(do.Name, ShouldEqual, activity)
+		So(do.ParentSpanID.IsValid(), ShouldBeFalse)
+		So(do.Status, ShouldEqual, tracing.StatusOK)
+		So(do.Attributes[0].Key, ShouldEqual, "retryset")
+		So(do.Attributes[1:], ShouldResemble, []tracing.Attribute{
+			{Key: "retried", Value: 1},
+			{Key: "stoppedBecause", Value: string(BecauseErrorNil)},
+		})
+		So(len(do.Events), ShouldEqual, 1)
+		So(do.Events[0].Name, ShouldEqual, "backoff sleep")
+		So(do.Events[0].Attributes, ShouldResemble, []tracing.Attribute{{Key: "duration", Value: 1 * time.Millisecond}})
+
+		for i, span := range []*tracing.Span{first, second} {
+			So(span.Name, ShouldEqual, "attempt")
+			So(span.TraceID, ShouldEqual, do.TraceID)
+			So(span.ParentSpanID, ShouldEqual, do.SpanID)
+			So(span.Attributes, ShouldResemble, []tracing.Attribute{{Key: "retries", Value: i}})
+			So(opSpans[i], ShouldEqual, span)
+		}
+
+		So(first.Status, ShouldEqual, tracing.StatusError)
+		So(first.StatusMessage, ShouldEqual, ErrOp.Error())
+		So(second.Status, ShouldEqual, tracing.StatusOK)
+
+		Convey("And logs include the trace ID and the retryset", func() {
+			lmsg := buff.String()
+			So(lmsg, ShouldContainSubstring, "retryset="+do.Attributes[0].Value.(string))
+			So(lmsg, ShouldContainSubstring, "traceid="+do.TraceID.String())
+			So(lmsg, ShouldContainSubstring, "spanid="+do.SpanID.String())
+		})
+
+		Convey("Failures are recorded on the Do() Span", func() {
+			exporter.Reset()
+			status = Do(ctx, func() error { return ErrOp }, &UntilLimit{Max: 0}, bo, activity)
+			So(status.Err, ShouldEqual, ErrOp)
+
+			spans = exporter.Spans()
+			So(len(spans), ShouldEqual, 2)
+			So(spans[1].Status, ShouldEqual, tracing.StatusError)
+			So(spans[1].StatusMessage, ShouldEqual, ErrOp.Error())
+		})
+	})
+
+	Convey("With tracing disabled, Do() works without Spans", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		status := DoContext(ctx, func(ctx context.Context) error {
+			So(tracing.SpanFromContext(ctx), ShouldBeNil)
+
+			return nil
+		}, &UntilNoError{}, bo, activity)
+		So(status.Err, ShouldBeNil)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("Do() records metrics per activity", t, func() {
+		activity := "testing metrics"
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+
+		calls, attempts := callsCounter().Value(activity), attemptsCounter().Value(activity)
+		gaveUp := gaveUpCounter().Value(activity, string(BecauseLimitReached))
+
+		Do(ctx, func() error { return nil }, &UntilNoError{}, bo, activity)
+		Do(ctx, func() error { return ErrOp }, &UntilLimit{Max: 2}, bo, activity)
+
+		So(callsCounter().Value(activity), ShouldEqual, calls+2)
+		So(attemptsCounter().Value(activity), ShouldEqual, attempts+4)
+		So(gaveUpCounter().Value(activity, string(BecauseLimitReached)), ShouldEqual, gaveUp+1)
+		So(gaveUpCounter().Value(activity, string(BecauseErrorNil)), ShouldEqual, 0)
+	})
 }
@@ -0,0 +1,99 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAttemptTimeout is the error recorded for an attempt that did not complete
+// within the duration supplied to the AttemptTimeout() Option.
+var ErrAttemptTimeout = errors.New("attempt timed out")
+
+// AttemptTimeout returns an Option that gives each attempt at running the
+// Operation its own context with the given timeout. Attempts that are still
+// running after the timeout are abandoned (though they will continue to run in
+// the background until they return) and recorded as having returned
+// ErrAttemptTimeout, and then the next attempt is made as normal.
+//
+// ContextOperations passed to DoContext() should return when their context is
+// cancelled. For the other Operation types, the timeout still applies, but they
+// can't be told to stop.
+func AttemptTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.attemptTimeout = timeout
+	}
+}
+
+// attemptResult holds the return values of a valueOperation.
+type attemptResult struct {
+	value interface{}
+	err   error
+}
+
+// runSingle runs our op once with a context derived from the given one, subject
+// to any attempt timeout, returning its value and error.
+func (r *retrier) runSingle(parent context.Context) (interface{}, error) {
+	if r.opts.attemptTimeout <= 0 {
+		return r.op(parent)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, r.opts.attemptTimeout)
+	defer cancel()
+
+	resultCh := make(chan attemptResult, 1)
+
+	go func() {
+		value, err := r.op(ctx)
+		resultCh <- attemptResult{value: value, err: err}
+	}()
+
+	select {
+	case result := <-resultCh:
+		return attemptOutcome(parent, ctx, result)
+	case <-ctx.Done():
+		return attemptOutcome(parent, ctx, attemptResult{err: ctx.Err()})
+	}
+}
+
+// attemptOutcome returns the value and error of the given result, or
+// ErrAttemptTimeout if it has an error and the given attempt context has timed
+// out.
+func attemptOutcome(parent, attemptCtx context.Context, result attemptResult) (interface{}, error) {
+	if result.err != nil && attemptTimedOut(parent, attemptCtx) {
+		return nil, ErrAttemptTimeout
+	}
+
+	return result.value, result.err
+}
+
+// attemptTimedOut returns true if the given attempt context has passed its
+// deadline while its parent context is still open.
+func attemptTimedOut(parent, attemptCtx context.Context) bool {
+	return errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && parent.Err() == nil
+}
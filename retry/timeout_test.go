@@ -0,0 +1,120 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	activity := "doing foo"
+	timeout := 10 * time.Millisecond
+
+	Convey("Given a Backoff and a ContextOperation that hangs until its context is done the first time", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		var count int32
+		op := func(ctx context.Context) error {
+			if atomic.AddInt32(&count, 1) == 1 {
+				<-ctx.Done()
+
+				return ctx.Err()
+			}
+
+			return nil
+		}
+
+		Convey("DoContext with an AttemptTimeout times out the attempt and tries again", func() {
+			status := DoContext(ctx, op, &UntilNoError{}, bo, activity, AttemptTimeout(timeout))
+			So(status.Retried, ShouldEqual, 1)
+			So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+			So(atomic.LoadInt32(&count), ShouldEqual, 2)
+		})
+
+		Convey("Timed out attempts are recorded as ErrAttemptTimeout", func() {
+			var errs []error
+
+			DoContext(ctx, op, &UntilNoError{}, bo, activity, AttemptTimeout(timeout),
+				OnRetry(func(ctx context.Context, attempt Attempt) {
+					errs = append(errs, attempt.Err)
+				}))
+			So(errs, ShouldResemble, []error{ErrAttemptTimeout})
+
+			status := DoContext(ctx, func(ctx context.Context) error {
+				<-ctx.Done()
+
+				return ctx.Err()
+			}, &UntilLimit{Max: 1}, bo, activity, AttemptTimeout(timeout))
+			So(status.StoppedBecause, ShouldEqual, BecauseLimitReached)
+			So(errors.Is(status, ErrAttemptTimeout), ShouldBeTrue)
+		})
+
+		Convey("Cancelling the overall context is not treated as a timeout", func() {
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			status := DoContext(cctx, op, &UntilNoError{}, bo, activity, AttemptTimeout(1*time.Second))
+			So(status.StoppedBecause, ShouldEqual, BecauseContextClosed)
+			So(errors.Is(status, context.DeadlineExceeded), ShouldBeTrue)
+			So(atomic.LoadInt32(&count), ShouldEqual, 1)
+		})
+
+		Convey("Without an AttemptTimeout, the attempt context has no deadline", func() {
+			status := DoContext(ctx, func(ctx context.Context) error {
+				_, hasDeadline := ctx.Deadline()
+				So(hasDeadline, ShouldBeFalse)
+
+				return nil
+			}, &UntilNoError{}, bo, activity)
+			So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+		})
+	})
+
+	Convey("Operations that ignore contexts are abandoned after an AttemptTimeout", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		block := make(chan bool)
+		defer close(block)
+
+		start := time.Now()
+		value, status := DoValue(ctx, func() (int, error) {
+			<-block
+
+			return 1, nil
+		}, &UntilLimit{Max: 1}, bo, activity, AttemptTimeout(timeout))
+		So(value, ShouldEqual, 0)
+		So(status.Retried, ShouldEqual, 1)
+		So(status.Err, ShouldEqual, ErrAttemptTimeout)
+		So(time.Since(start), ShouldBeLessThan, 1*time.Second)
+	})
+}
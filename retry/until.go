@@ -25,17 +25,28 @@
 
 package retry
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/wtsi-ssg/wr/backoff"
+)
 
 // Reason is the type of our Because* constants.
 type Reason string
 
 // Because* constants are returned by Until.ShouldStop().
 const (
-	BecauseLimitReached  Reason = "limit reached"
-	BecauseErrorNil      Reason = "there was no error"
-	BecauseContextClosed Reason = "context closed"
-	doNotStop            Reason = ""
+	BecauseLimitReached       Reason = "limit reached"
+	BecauseErrorNil           Reason = "there was no error"
+	BecauseContextClosed      Reason = "context closed"
+	BecauseTimeBudgetExceeded Reason = "time budget exceeded"
+	BecausePermanentError     Reason = "error was permanent"
+	BecauseErrorMatched       Reason = "error matched"
+	BecauseErrorNotTransient  Reason = "error was not transient"
+	BecauseValueAccepted      Reason = "value was accepted"
+	BecauseBudgetExhausted    Reason = "retry budget exhausted"
+	doNotStop                 Reason = ""
 )
 
 // Until is used by Retry to determine when to stop retrying.
@@ -62,6 +73,55 @@ func (u Untils) ShouldStop(retries int, err error) Reason {
 	return doNotStop
 }
 
+// startBudget starts the time budget of any of the elements of this slice that
+// have one.
+func (u Untils) startBudget() {
+	for _, until := range u {
+		if b, ok := until.(budgeted); ok {
+			b.startBudget()
+		}
+	}
+}
+
+// budgetDeadline returns the earliest time budget deadline of the elements of
+// this slice, or the zero time if none of them have one.
+func (u Untils) budgetDeadline() time.Time {
+	var earliest time.Time
+
+	for _, until := range u {
+		b, ok := until.(budgeted)
+		if !ok {
+			continue
+		}
+
+		earliest = earlierDeadline(earliest, b.budgetDeadline())
+	}
+
+	return earliest
+}
+
+// earlierDeadline returns the earlier of the given deadlines, where the zero
+// time means no deadline.
+func earlierDeadline(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// budgeted is implemented by Untils that stop retrying after a time budget has
+// been used up. Do() uses it to start the budget and to avoid sleeping beyond
+// it.
+type budgeted interface {
+	// startBudget starts the time budget from now.
+	startBudget()
+
+	// budgetDeadline returns the time at which the budget will be used up, or
+	// the zero time if there is no budget.
+	budgetDeadline() time.Time
+}
+
 // UntilLimit implements Until, stopping retries after Max retries. A Max
 // of 0 means "don't retry". A Max of 1 means up to 1 retry will be attempted,
 // and so on.
@@ -93,6 +153,56 @@ func (u *UntilNoError) ShouldStop(retries int, err error) Reason {
 	return doNotStop
 }
 
+// UntilElapsed implements Until, stopping retries once Max time has elapsed
+// since Do() started. Do() will also shorten its final sleep so as not to
+// sleep beyond Max.
+//
+// Clock is used to tell the time, and should be the same Clock used by the
+// Sleeper of the Backoff passed to Do(). If nil, real time is used.
+//
+// An UntilElapsed should not be shared between concurrent Do() calls.
+type UntilElapsed struct {
+	Max   time.Duration
+	Clock backoff.Clock
+
+	deadline time.Time
+}
+
+// ShouldStop returns BecauseTimeBudgetExceeded once Max time has elapsed since
+// the budget was started by Do(), or since the first ShouldStop() call if not
+// used with Do(). retries and err are not considered.
+func (u *UntilElapsed) ShouldStop(retries int, err error) Reason {
+	if u.deadline.IsZero() {
+		u.startBudget()
+	}
+
+	if !u.now().Before(u.deadline) {
+		return BecauseTimeBudgetExceeded
+	}
+
+	return doNotStop
+}
+
+// startBudget starts our time budget from now.
+func (u *UntilElapsed) startBudget() {
+	u.deadline = u.now().Add(u.Max)
+}
+
+// budgetDeadline returns the time at which our budget will be used up.
+func (u *UntilElapsed) budgetDeadline() time.Time {
+	return u.deadline
+}
+
+// now returns the current time according to our Clock, or real time if we have
+// no Clock.
+func (u *UntilElapsed) now() time.Time {
+	if u.Clock == nil {
+		return time.Now()
+	}
+
+	return u.Clock.Now()
+}
+
 // untilContext implements Until, stopping retries after the context has been
 // closed.
 type untilContext struct {
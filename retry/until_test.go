@@ -29,8 +29,10 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
 )
 
 var ErrNormal error = errors.New("normal")
@@ -77,4 +79,41 @@ func TestUntil(t *testing.T) {
 		cancel()
 		So(u.ShouldStop(1, ErrNormal), ShouldEqual, BecauseContextClosed)
 	})
+
+	Convey("UntilElapsed stops after the time budget is used up", t, func() {
+		var _ Until = (*UntilElapsed)(nil)
+		clock := bm.NewClock(time.Now())
+		u := &UntilElapsed{Max: 2 * time.Second, Clock: clock}
+		So(u.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+		So(u.budgetDeadline(), ShouldEqual, clock.Now().Add(2*time.Second))
+
+		clock.Advance(1 * time.Second)
+		So(u.ShouldStop(1, ErrNormal), ShouldEqual, doNotStop)
+		clock.Advance(1 * time.Second)
+		So(u.ShouldStop(2, ErrNormal), ShouldEqual, BecauseTimeBudgetExceeded)
+
+		Convey("And can be restarted", func() {
+			u.startBudget()
+			So(u.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+		})
+
+		Convey("And uses real time without a Clock", func() {
+			u = &UntilElapsed{Max: 1 * time.Millisecond}
+			So(u.ShouldStop(0, ErrNormal), ShouldEqual, doNotStop)
+			<-time.After(2 * time.Millisecond)
+			So(u.ShouldStop(0, ErrNormal), ShouldEqual, BecauseTimeBudgetExceeded)
+		})
+	})
+
+	Convey("Untils start and report the earliest time budget of their elements", t, func() {
+		clock := bm.NewClock(time.Now())
+		u1 := &UntilElapsed{Max: 2 * time.Second, Clock: clock}
+		u2 := &UntilElapsed{Max: 1 * time.Second, Clock: clock}
+		u := Untils{&UntilLimit{Max: 2}, u1, Untils{u2}}
+		So(u.budgetDeadline(), ShouldBeZeroValue)
+
+		u.startBudget()
+		So(u.budgetDeadline(), ShouldEqual, clock.Now().Add(1*time.Second))
+		So(u1.budgetDeadline(), ShouldEqual, clock.Now().Add(2*time.Second))
+	})
 }
@@ -0,0 +1,107 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+
+	"github.com/wtsi-ssg/wr/backoff"
+)
+
+// ValueOperation is passed to DoValue() and is the code you would like to
+// retry, when that code returns a value you're interested in.
+type ValueOperation[T any] func() (T, error)
+
+// DoValue is like Do(), but for Operations that return a value. The value
+// returned by the final attempt is returned along with the Status (or the zero
+// value if the final attempt timed out).
+//
+// Any UntilValue in until will be able to stop retries based on the values
+// returned by op.
+func DoValue[T any](ctx context.Context, op ValueOperation[T], until Until, bo *backoff.Backoff,
+	activity string, opts ...Option) (T, *Status) {
+	r := newRetrier(ctx, func(context.Context) (interface{}, error) {
+		return op()
+	}, until, bo, activity, opts)
+	status := r.do()
+
+	value, ok := r.value.(T)
+	if !ok {
+		var zero T
+
+		return zero, status
+	}
+
+	return value, status
+}
+
+// valueObserver is implemented by Untils that want to know the values returned
+// by ValueOperations in DoValue().
+type valueObserver interface {
+	// observeValue is given the value returned by the latest attempt.
+	observeValue(value interface{})
+}
+
+// observeValue passes the given value to any of the elements of this slice that
+// want it.
+func (u Untils) observeValue(value interface{}) {
+	for _, until := range u {
+		if observer, ok := until.(valueObserver); ok {
+			observer.observeValue(value)
+		}
+	}
+}
+
+// UntilValue implements Until for use with DoValue(), stopping retries when
+// Accept returns true for the value returned by the latest attempt.
+//
+// Only the value of the latest attempt is remembered, and attempts that return
+// no value (because they timed out or a gate failed) are never accepted, so an
+// UntilValue can be reused by sequential DoValue() calls. It should not be
+// shared between concurrent DoValue() calls.
+type UntilValue[T any] struct {
+	Accept func(value T) bool
+
+	value    T
+	observed bool
+}
+
+// ShouldStop returns BecauseValueAccepted when Accept returns true for the
+// latest value from DoValue(). retries and err are not considered. If not used
+// with DoValue(), never stops.
+func (u *UntilValue[T]) ShouldStop(retries int, err error) Reason {
+	if u.observed && u.Accept(u.value) {
+		return BecauseValueAccepted
+	}
+
+	return doNotStop
+}
+
+// observeValue stores the given value if it is of our type, or else forgets
+// any previously observed value.
+func (u *UntilValue[T]) observeValue(value interface{}) {
+	u.value, u.observed = value.(T)
+}
@@ -0,0 +1,121 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/wtsi-ssg/wr/backoff"
+	bm "github.com/wtsi-ssg/wr/backoff/mock"
+)
+
+func TestValue(t *testing.T) {
+	ctx := context.Background()
+	isPositive := func(n int) bool {
+		return n > 0
+	}
+
+	Convey("UntilValue stops when it accepts the observed value", t, func() {
+		var _ Until = (*UntilValue[int])(nil)
+		u := &UntilValue[int]{Accept: isPositive}
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+
+		u.observeValue(0)
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+
+		u.observeValue("wrong type")
+		So(u.ShouldStop(0, nil), ShouldEqual, doNotStop)
+
+		Untils{&UntilLimit{Max: 5}, u}.observeValue(1)
+		So(u.ShouldStop(1, ErrNormal), ShouldEqual, BecauseValueAccepted)
+
+		u.observeValue(nil)
+		So(u.ShouldStop(2, ErrNormal), ShouldEqual, doNotStop)
+	})
+
+	Convey("DoValue returns the final value and Status", t, func() {
+		bo := &backoff.Backoff{Min: 1 * time.Millisecond, Max: 1 * time.Millisecond, Sleeper: &bm.Sleeper{}}
+		count := 0
+		op := func() (int, error) {
+			count++
+			if count < 3 {
+				return 0, ErrOp
+			}
+
+			return count, nil
+		}
+
+		value, status := DoValue(ctx, op, &UntilNoError{}, bo, "counting")
+		So(value, ShouldEqual, 3)
+		So(status.Retried, ShouldEqual, 2)
+		So(status.StoppedBecause, ShouldEqual, BecauseErrorNil)
+
+		Convey("And can stop based on the value", func() {
+			count = -2
+			op = func() (int, error) {
+				count++
+
+				return count, nil
+			}
+
+			value, status = DoValue(ctx, op, &Untils{&UntilValue[int]{Accept: isPositive}, &UntilLimit{Max: 5}},
+				bo, "counting")
+			So(value, ShouldEqual, 1)
+			So(status.Retried, ShouldEqual, 2)
+			So(status.StoppedBecause, ShouldEqual, BecauseValueAccepted)
+			So(status.Err, ShouldBeNil)
+		})
+
+		Convey("UntilValues can be reused without remembering old values", func() {
+			u := &UntilValue[int]{Accept: isPositive}
+			op = func() (int, error) {
+				return 1, nil
+			}
+
+			value, status = DoValue(ctx, op, u, bo, "counting")
+			So(value, ShouldEqual, 1)
+			So(status.StoppedBecause, ShouldEqual, BecauseValueAccepted)
+
+			block := make(chan struct{})
+			defer close(block)
+
+			op = func() (int, error) {
+				<-block
+
+				return 1, nil
+			}
+
+			value, status = DoValue(ctx, op, Untils{u, &UntilLimit{Max: 0}}, bo, "counting",
+				AttemptTimeout(time.Millisecond))
+			So(value, ShouldEqual, 0)
+			So(status.StoppedBecause, ShouldEqual, BecauseLimitReached)
+			So(status.Err, ShouldEqual, ErrAttemptTimeout)
+		})
+	})
+}
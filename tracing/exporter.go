@@ -0,0 +1,97 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Exporter is something that Spans are sent to once they have ended, eg. to be
+// stored or forwarded to a tracing system. Implement it to plug in an
+// exporter for the OpenTelemetry protocol. Implementations must be concurrent
+// safe, and should not block for long.
+type Exporter interface {
+	// Export is called with each Span once it has ended.
+	Export(span *Span)
+}
+
+// InMemoryExporter is an Exporter that keeps Spans in memory, which is useful
+// for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Export implements Exporter, storing the Span.
+func (e *InMemoryExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the Spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	spans := make([]*Span, len(e.spans))
+	copy(spans, e.spans)
+
+	return spans
+}
+
+// Reset forgets all the Spans exported so far.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
+
+// WriterExporter is an Exporter that writes Spans as lines of JSON to an
+// io.Writer. Create one with NewWriterExporter(). Use os.Stdout as the
+// writer for a stdout exporter.
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns a WriterExporter that writes to the given writer.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// Export implements Exporter, writing the Span as a line of JSON. Write errors
+// are ignored, since tracing should not interfere with the traced work.
+func (e *WriterExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.enc.Encode(span)
+}
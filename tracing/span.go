@@ -0,0 +1,275 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StatusCode is the type of our Status* constants, which describe the outcome
+// of the work a Span represents.
+type StatusCode string
+
+// Status* constants are the possible values of Span.Status, matching the
+// OpenTelemetry status codes.
+const (
+	StatusUnset StatusCode = "unset"
+	StatusOK    StatusCode = "ok"
+	StatusError StatusCode = "error"
+)
+
+// Attribute is a key and value describing a Span or Event.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Event is something that happened at a particular time during a Span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// Span records a unit of work within a trace. Create them with Start().
+//
+// The exported fields should not be altered, and should only be read after the
+// Span has ended, eg. by an Exporter. The methods are concurrent safe, and
+// after End() they do nothing. All methods can be called on a nil Span, which
+// is what Start() returns while tracing is disabled.
+type Span struct {
+	Name          string
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    []Attribute
+	Events        []Event
+	Status        StatusCode
+	StatusMessage string
+
+	exporter Exporter
+	mu       sync.Mutex
+	ended    bool
+}
+
+// newSpan returns a started Span with a new SpanID, that will be sent to the
+// given Exporter when ended.
+func newSpan(name string, e Exporter) *Span {
+	return &Span{
+		Name:      name,
+		SpanID:    newSpanID(),
+		StartTime: time.Now(),
+		Status:    StatusUnset,
+		exporter:  e,
+	}
+}
+
+// SetAttributes sets the given attributes (alternating keys and values, like
+// the args to clog.Debug()) on the Span. Setting an attribute that was already
+// set replaces its value without changing its position. A final key without a
+// value gets a nil value.
+func (s *Span) SetAttributes(keyvals ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.Attributes = setAttributes(s.Attributes, keyvals)
+}
+
+// setAttributes returns attrs with the given alternating keys and values set.
+func setAttributes(attrs []Attribute, keyvals []interface{}) []Attribute {
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{}
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+
+		attrs = setAttribute(attrs, fmt.Sprint(keyvals[i]), val)
+	}
+
+	return attrs
+}
+
+// setAttribute returns attrs with the given key set to the given value.
+func setAttribute(attrs []Attribute, key string, val interface{}) []Attribute {
+	for i := range attrs {
+		if attrs[i].Key == key {
+			attrs[i].Value = val
+
+			return attrs
+		}
+	}
+
+	return append(attrs, Attribute{Key: key, Value: val})
+}
+
+// AddEvent records that something with the given name and attributes
+// (alternating keys and values) happened now.
+func (s *Span) AddEvent(name string, keyvals ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	s.Events = append(s.Events, Event{Name: name, Time: time.Now(), Attributes: setAttributes(nil, keyvals)})
+}
+
+// SetError sets our Status to StatusError with the given error's message, if
+// err is not nil. Otherwise, sets our Status to StatusOK.
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+
+	if err == nil {
+		s.Status, s.StatusMessage = StatusOK, ""
+
+		return
+	}
+
+	s.Status, s.StatusMessage = StatusError, err.Error()
+}
+
+// End records the end time of the Span and sends it to the Exporter that was
+// set when it was started. Only the first call does anything.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+
+		return
+	}
+
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	s.exporter.Export(s)
+}
+
+// spanJSON is the JSON representation of a Span, which uses the field names of
+// the OpenTelemetry protocol's JSON encoding.
+type spanJSON struct {
+	TraceID       string                 `json:"traceId"`
+	SpanID        string                 `json:"spanId"`
+	ParentSpanID  string                 `json:"parentSpanId,omitempty"`
+	Name          string                 `json:"name"`
+	StartTime     time.Time              `json:"startTime"`
+	EndTime       time.Time              `json:"endTime"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []eventJSON            `json:"events,omitempty"`
+	Status        StatusCode             `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+}
+
+// eventJSON is the JSON representation of an Event.
+type eventJSON struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, representing IDs as hex strings and
+// attributes as objects.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	sj := &spanJSON{
+		TraceID:       s.TraceID.String(),
+		SpanID:        s.SpanID.String(),
+		Name:          s.Name,
+		StartTime:     s.StartTime,
+		EndTime:       s.EndTime,
+		Attributes:    attributesMap(s.Attributes),
+		Status:        s.Status,
+		StatusMessage: s.StatusMessage,
+	}
+
+	if s.ParentSpanID.IsValid() {
+		sj.ParentSpanID = s.ParentSpanID.String()
+	}
+
+	for _, event := range s.Events {
+		sj.Events = append(sj.Events, eventJSON{
+			Name:       event.Name,
+			Time:       event.Time,
+			Attributes: attributesMap(event.Attributes),
+		})
+	}
+
+	return json.Marshal(sj)
+}
+
+// attributesMap returns the given attributes as a map, or nil if there are
+// none. Values that are errors or fmt.Stringers are stringified.
+func attributesMap(attrs []Attribute) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(attrs))
+
+	for _, attr := range attrs {
+		switch v := attr.Value.(type) {
+		case error:
+			m[attr.Key] = v.Error()
+		case fmt.Stringer:
+			m[attr.Key] = v.String()
+		default:
+			m[attr.Key] = v
+		}
+	}
+
+	return m
+}
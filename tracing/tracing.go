@@ -0,0 +1,163 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+// package tracing is used to record what happens during an operation as a tree
+// of timed Spans, compatible with the OpenTelemetry data model, and to send
+// them to a pluggable Exporter.
+//
+// Tracing is disabled until you SetExporter(), and while disabled, Start()
+// returns nil Spans, all of whose methods are no-ops, so it costs almost
+// nothing to leave tracing calls in your code.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// TraceID identifies a trace, which is a tree of Spans. It is compatible with
+// OpenTelemetry and W3C Trace Context trace IDs.
+type TraceID [16]byte
+
+// String returns the TraceID as 32 lowercase hex characters.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns true if the TraceID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a Span within a trace. It is compatible with OpenTelemetry
+// and W3C Trace Context span (parent) IDs.
+type SpanID [8]byte
+
+// String returns the SpanID as 16 lowercase hex characters.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns true if the SpanID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// spanKey is the type of the key under which we store Spans in contexts.
+type spanKey struct{}
+
+// exporter holds the global Exporter.
+var exporter struct { //nolint:gochecknoglobals // one Exporter per process
+	sync.RWMutex
+	e Exporter
+}
+
+// SetExporter enables tracing, with ended Spans being sent to the given
+// Exporter. Supplying nil disables tracing again.
+func SetExporter(e Exporter) {
+	exporter.Lock()
+	defer exporter.Unlock()
+
+	exporter.e = e
+}
+
+// currentExporter returns the global Exporter, which may be nil.
+func currentExporter() Exporter {
+	exporter.RLock()
+	defer exporter.RUnlock()
+
+	return exporter.e
+}
+
+// Start starts a new Span with the given name and attributes (alternating keys
+// and values, like the args to clog.Debug()), returning it along with a
+// context that knows about it. If the given context already knows about a
+// Span, the new Span is its child, in the same trace; otherwise the new Span
+// starts a new trace.
+//
+// You must End() the returned Span when the work it represents is complete.
+// If tracing is disabled, returns the given context and a nil Span.
+func Start(ctx context.Context, name string, keyvals ...interface{}) (context.Context, *Span) {
+	e := currentExporter()
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := newSpan(name, e)
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = newTraceID()
+	}
+
+	span.SetAttributes(keyvals...)
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext returns the Span that the given context knows about, or nil
+// if it doesn't know about one.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+// newTraceID returns a random TraceID.
+func newTraceID() TraceID {
+	var id TraceID
+
+	randomBytes(id[:])
+
+	return id
+}
+
+// newSpanID returns a random SpanID.
+func newSpanID() SpanID {
+	var id SpanID
+
+	randomBytes(id[:])
+
+	return id
+}
+
+// randomBytes fills b with cryptographically random bytes. crypto/rand does not
+// fail on supported platforms, but if it did, IDs would be invalid (all zero)
+// rather than predictable.
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*******************************************************************************
+ * Copyright (c) 2020 Genome Research Ltd.
+ *
+ * Author: Sendu Bala <sb10@sanger.ac.uk>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining
+ * a copy of this software and associated documentation files (the
+ * "Software"), to deal in the Software without restriction, including
+ * without limitation the rights to use, copy, modify, merge, publish,
+ * distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so, subject to
+ * the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included
+ * in all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+ * EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+ * MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+ * IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY
+ * CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT,
+ * TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ ******************************************************************************/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTracing(t *testing.T) {
+	background := context.Background()
+
+	Convey("With tracing disabled, Start() returns nil Spans that do nothing", t, func() {
+		ctx, span := Start(background, "foo", "a", 1)
+		So(span, ShouldBeNil)
+		So(ctx == background, ShouldBeTrue)
+		So(SpanFromContext(ctx), ShouldBeNil)
+		So(SpanFromContext(nil), ShouldBeNil)
+
+		span.SetAttributes("b", 2)
+		span.AddEvent("event")
+		span.SetError(errors.New("err"))
+		span.End()
+	})
+
+	Convey("With tracing enabled to an InMemoryExporter", t, func() {
+		exporter := &InMemoryExporter{}
+		SetExporter(exporter)
+		defer SetExporter(nil)
+
+		ctx, root := Start(background, "root", "a", 1, "b", 2)
+		So(root, ShouldNotBeNil)
+		So(SpanFromContext(ctx), ShouldEqual, root)
+		So(root.TraceID.IsValid(), ShouldBeTrue)
+		So(root.SpanID.IsValid(), ShouldBeTrue)
+		So(root.ParentSpanID.IsValid(), ShouldBeFalse)
+		So(len(root.TraceID.String()), ShouldEqual, 32)
+		So(len(root.SpanID.String()), ShouldEqual, 16)
+		So(root.Status, ShouldEqual, StatusUnset)
+
+		Convey("Child Spans share the trace", func() {
+			_, child := Start(ctx, "child")
+			So(child.TraceID, ShouldEqual, root.TraceID)
+			So(child.ParentSpanID, ShouldEqual, root.SpanID)
+			So(child.SpanID, ShouldNotEqual, root.SpanID)
+
+			_, other := Start(background, "other")
+			So(other.TraceID, ShouldNotEqual, root.TraceID)
+		})
+
+		Convey("Spans record attributes, events and errors, and are exported once on End()", func() {
+			root.SetAttributes("a", 3, "c")
+			So(root.Attributes, ShouldResemble, []Attribute{{"a", 3}, {"b", 2}, {"c", nil}})
+
+			root.AddEvent("happened", "x", "y")
+			So(len(root.Events), ShouldEqual, 1)
+			So(root.Events[0].Name, ShouldEqual, "happened")
+			So(root.Events[0].Attributes, ShouldResemble, []Attribute{{"x", "y"}})
+			So(root.Events[0].Time, ShouldHappenOnOrAfter, root.StartTime)
+
+			root.SetError(nil)
+			So(root.Status, ShouldEqual, StatusOK)
+			root.SetError(errors.New("failed"))
+			So(root.Status, ShouldEqual, StatusError)
+			So(root.StatusMessage, ShouldEqual, "failed")
+
+			So(exporter.Spans(), ShouldBeEmpty)
+			root.End()
+			So(exporter.Spans(), ShouldResemble, []*Span{root})
+			So(root.EndTime, ShouldHappenOnOrAfter, root.StartTime)
+
+			root.End()
+			root.SetAttributes("d", 4)
+			root.AddEvent("later")
+			root.SetError(nil)
+			So(len(exporter.Spans()), ShouldEqual, 1)
+			So(len(root.Attributes), ShouldEqual, 3)
+			So(len(root.Events), ShouldEqual, 1)
+			So(root.Status, ShouldEqual, StatusError)
+
+			exporter.Reset()
+			So(exporter.Spans(), ShouldBeEmpty)
+		})
+
+		Convey("Span methods are concurrent safe", func() {
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer wg.Done()
+
+					root.AddEvent("event", "i", i)
+					root.SetAttributes("i", i)
+				}(i)
+			}
+
+			wg.Wait()
+			root.End()
+			So(len(root.Events), ShouldEqual, 10)
+		})
+
+		Convey("Disabling tracing doesn't affect started Spans", func() {
+			SetExporter(nil)
+			root.End()
+			So(len(exporter.Spans()), ShouldEqual, 1)
+		})
+	})
+
+	Convey("A WriterExporter writes Spans as lines of JSON", t, func() {
+		buff := new(bytes.Buffer)
+		SetExporter(NewWriterExporter(buff))
+		defer SetExporter(nil)
+
+		ctx, root := Start(background, "root", "err", errors.New("e"))
+		_, child := Start(ctx, "child")
+		child.AddEvent("sleep", "n", 1)
+		child.SetError(errors.New("failed"))
+		child.End()
+		root.End()
+
+		lines := bytes.Split(bytes.TrimSpace(buff.Bytes()), []byte("\n"))
+		So(len(lines), ShouldEqual, 2)
+
+		var decoded map[string]interface{}
+		err := json.Unmarshal(lines[0], &decoded)
+		So(err, ShouldBeNil)
+		So(decoded["name"], ShouldEqual, "child")
+		So(decoded["traceId"], ShouldEqual, root.TraceID.String())
+		So(decoded["spanId"], ShouldEqual, child.SpanID.String())
+		So(decoded["parentSpanId"], ShouldEqual, root.SpanID.String())
+		So(decoded["status"], ShouldEqual, "error")
+		So(decoded["statusMessage"], ShouldEqual, "failed")
+		events, ok := decoded["events"].([]interface{})
+		So(ok, ShouldBeTrue)
+		So(len(events), ShouldEqual, 1)
+		So(events[0].(map[string]interface{})["name"], ShouldEqual, "sleep")
+
+		decoded = nil
+		err = json.Unmarshal(lines[1], &decoded)
+		So(err, ShouldBeNil)
+		So(decoded["name"], ShouldEqual, "root")
+		So(decoded["attributes"], ShouldResemble, map[string]interface{}{"err": "e"})
+		So(decoded, ShouldNotContainKey, "parentSpanId")
+	})
+}